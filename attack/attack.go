@@ -0,0 +1,210 @@
+package attack
+
+import (
+	"math"
+	"sort"
+)
+
+// WordFreq is an entry of the attacker's auxiliary word frequency list
+type WordFreq struct {
+	Word  string
+	Count float64
+}
+
+//MARK: Rank matching
+
+// RankMatch is the classic frequency analysis attack: the i-th most frequent
+// tag is guessed to be the i-th most frequent auxiliary word. Only the top
+// most frequent tags are guessed, all of them if top is 0.
+func RankMatch(tagCounts map[string]int, aux []WordFreq, top int) (guesses map[string]string) {
+	tags := topOf(rankTags(tagCounts), top)
+	words := rankWords(aux)
+
+	guesses = make(map[string]string)
+	for i, t := range tags {
+		if i >= len(words) {
+			break
+		}
+		guesses[t] = words[i]
+	}
+
+	return
+}
+
+func rankTags(tagCounts map[string]int) (tags []string) {
+	for t := range tagCounts {
+		tags = append(tags, t)
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tagCounts[tags[i]] != tagCounts[tags[j]] {
+			return tagCounts[tags[i]] > tagCounts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	return
+}
+
+func topOf(ranked []string, top int) []string {
+	if top > 0 && len(ranked) > top {
+		return ranked[:top]
+	}
+	return ranked
+}
+
+func rankWords(aux []WordFreq) (words []string) {
+	sorted := append([]WordFreq(nil), aux...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Count > sorted[j].Count })
+
+	for _, wf := range sorted {
+		words = append(words, wf.Word)
+	}
+	return
+}
+
+//MARK: Co-occurrence
+
+// Cooccurrence counts, for every pair of items, the documents containing both.
+// Counts is sparse: pairs that never co-occur have no entry.
+type Cooccurrence struct {
+	Docs   int
+	Counts map[string]map[string]int
+}
+
+// NewCooccurrence builds the co-occurrence counts of a set of documents,
+// restricted to the top items in most documents, all of them if top is 0.
+func NewCooccurrence(docs [][]string, top int) (c Cooccurrence) {
+	c.Docs = len(docs)
+	c.Counts = make(map[string]map[string]int)
+
+	docFreq := make(map[string]int)
+	for _, doc := range docs {
+		for _, x := range distinct(doc) {
+			docFreq[x] += 1
+		}
+	}
+
+	vocab := make(map[string]bool)
+	for _, x := range topOf(rankTags(docFreq), top) {
+		vocab[x] = true
+	}
+
+	for _, doc := range docs {
+		var items []string
+		for _, x := range distinct(doc) {
+			if vocab[x] {
+				items = append(items, x)
+			}
+		}
+
+		for _, a := range items {
+			if c.Counts[a] == nil {
+				c.Counts[a] = make(map[string]int)
+			}
+			for _, b := range items {
+				c.Counts[a][b] += 1
+			}
+		}
+	}
+
+	return
+}
+
+func distinct(doc []string) (items []string) {
+	seen := make(map[string]bool)
+	for _, x := range doc {
+		if !seen[x] {
+			seen[x] = true
+			items = append(items, x)
+		}
+	}
+	return
+}
+
+func (c Cooccurrence) rate(a, b string) float64 {
+	if c.Docs == 0 {
+		return 0
+	}
+	return float64(c.Counts[a][b]) / float64(c.Docs)
+}
+
+// CooccurrenceMatch is an IKK-style attack. Starting from the seed guesses
+// for the most frequent tags, each remaining tag is matched to the unused
+// auxiliary word, among those of similar frequency rank, whose co-occurrence
+// with the matched words best fits the tag's co-occurrence with the matched
+// tags. Every round re-scores the tags against the previous round's guesses.
+// Scoring only visits the pairs that co-occur, as pairs absent from both
+// matrices add nothing to the fit.
+func CooccurrenceMatch(tags Cooccurrence, words Cooccurrence, seed map[string]string, window int, rounds int) (guesses map[string]string) {
+	guesses = make(map[string]string)
+	for t, w := range seed {
+		guesses[t] = w
+	}
+
+	tagCounts := make(map[string]int)
+	for t, row := range tags.Counts {
+		tagCounts[t] = row[t]
+	}
+
+	var aux []WordFreq
+	for w, row := range words.Counts {
+		aux = append(aux, WordFreq{w, float64(row[w])})
+	}
+
+	rankedTags := rankTags(tagCounts)
+	rankedWords := rankWords(aux)
+
+	for round := 0; round < rounds; round++ {
+		known := guesses
+		knownByWord := make(map[string][]string)
+		for kt, kw := range known {
+			knownByWord[kw] = append(knownByWord[kw], kt)
+		}
+
+		guesses = make(map[string]string)
+		used := make(map[string]bool)
+		for t, w := range seed {
+			guesses[t] = w
+			used[w] = true
+		}
+
+		for i, t := range rankedTags {
+			if _, ok := seed[t]; ok {
+				continue
+			}
+
+			best, bestScore := "", math.Inf(1)
+			for j := i - window; j <= i+window; j++ {
+				if j < 0 || j >= len(rankedWords) || used[rankedWords[j]] {
+					continue
+				}
+
+				w := rankedWords[j]
+				score := math.Abs(tags.rate(t, t) - words.rate(w, w))
+				for kt := range tags.Counts[t] {
+					if kw, ok := known[kt]; ok && kt != t {
+						score += math.Abs(tags.rate(t, kt) - words.rate(w, kw))
+					}
+				}
+				for kw := range words.Counts[w] {
+					for _, kt := range knownByWord[kw] {
+						if _, counted := tags.Counts[t][kt]; !counted && kt != t {
+							score += words.rate(w, kw)
+						}
+					}
+				}
+
+				if score < bestScore {
+					best, bestScore = w, score
+				}
+			}
+
+			if best != "" {
+				guesses[t] = best
+				used[best] = true
+			}
+		}
+	}
+
+	return
+}
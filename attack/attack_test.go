@@ -0,0 +1,76 @@
+package attack
+
+import "testing"
+
+var docs = [][]string{
+	{"chest", "pain", "stemi"},
+	{"chest", "pain", "nodule"},
+	{"chest", "pain"},
+	{"nodule", "lung"},
+	{"chest", "stemi"},
+	{"chest", "pain", "lung", "nodule"},
+}
+
+// tagged replaces every word with an opaque tag
+func tagged(docs [][]string) (tagDocs [][]string, truth map[string]string) {
+	truth = make(map[string]string)
+	for _, doc := range docs {
+		var tagDoc []string
+		for _, w := range doc {
+			t := "t_" + w
+			truth[t] = w
+			tagDoc = append(tagDoc, t)
+		}
+		tagDocs = append(tagDocs, tagDoc)
+	}
+	return
+}
+
+func TestRankMatch(t *testing.T) {
+	tagCounts := map[string]int{"a": 10, "b": 5, "c": 1}
+	aux := []WordFreq{{"the", 1000}, {"rare", 1}, {"of", 500}}
+
+	guesses := RankMatch(tagCounts, aux, 0)
+	if guesses["a"] != "the" || guesses["b"] != "of" || guesses["c"] != "rare" {
+		t.Errorf("Unexpected guesses: %v", guesses)
+	}
+}
+
+func TestCooccurrenceMatch(t *testing.T) {
+	tagDocs, truth := tagged(docs)
+
+	tags := NewCooccurrence(tagDocs, 0)
+	words := NewCooccurrence(docs, 0)
+
+	// with perfect knowledge of co-occurrence and one seed, all words fall
+	seed := map[string]string{"t_chest": "chest"}
+	guesses := CooccurrenceMatch(tags, words, seed, 5, 2)
+
+	for tag, w := range truth {
+		if guesses[tag] != w {
+			t.Errorf("Tag %s guessed %s. Expected %s.", tag, guesses[tag], w)
+		}
+	}
+}
+
+func TestRankMatchTop(t *testing.T) {
+	tagCounts := map[string]int{"a": 10, "b": 5, "c": 1}
+	aux := []WordFreq{{"the", 1000}, {"rare", 1}, {"of", 500}}
+
+	guesses := RankMatch(tagCounts, aux, 2)
+	if len(guesses) != 2 || guesses["a"] != "the" || guesses["b"] != "of" {
+		t.Errorf("Unexpected guesses: %v", guesses)
+	}
+}
+
+func TestCooccurrenceTop(t *testing.T) {
+	c := NewCooccurrence(docs, 2)
+
+	// chest and pain are in the most documents
+	if len(c.Counts) != 2 || c.Counts["chest"]["pain"] != 4 || c.Counts["chest"]["chest"] != 5 {
+		t.Errorf("Unexpected counts: %v", c.Counts)
+	}
+	if c.Docs != len(docs) {
+		t.Errorf("Docs is %d. Expected %d.", c.Docs, len(docs))
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/agrinman/alvis/attack"
+)
+
+// attackSimOptions describe how the plaintext corpus was encrypted, so that
+// its tokens line up with the frequency tags of the encrypted output.
+type attackSimOptions struct {
+	Format   string
+	Schema   Schema
+	PHI      *phiScrubber
+	Ranges   bool
+	MergeMap map[string]string
+	Seeds    int
+	Window   int
+	Rounds   int
+	Top      int
+}
+
+// tokenizer splits the plaintext notes as encrypt did
+func (opts attackSimOptions) tokenizer() tokenizer {
+	return tokenizer{Decimals: opts.Ranges, Placeholders: opts.PHI != nil}
+}
+
+// alignedCorpus is what the attacker sees (tags per note) next to the
+// ground truth (words per note) of the same notes.
+type alignedCorpus struct {
+	TagDocs    [][]string
+	WordDocs   [][]string
+	TagCounts  map[string]int
+	WordCounts map[string]int
+	Truth      map[string]string
+	Skipped    int
+}
+
+// attackResult is the recovery rate of one attack on one class of tokens
+type attackResult struct {
+	Attack          string  `json:"attack"`
+	Class           string  `json:"class"`
+	Tags            int     `json:"tags"`
+	RecoveredTags   int     `json:"recovered_tags"`
+	Tokens          int     `json:"tokens"`
+	RecoveredTokens int     `json:"recovered_tokens"`
+	TokenRate       float64 `json:"token_recovery_rate"`
+}
+
+//MARK: Alignment
+
+// alignCorpus pairs every plaintext file X with its encryption X.enc and
+// every plaintext token with the frequency tag it was encrypted to. Notes
+// whose token counts differ (e.g. scrubbed differently) are skipped.
+func alignCorpus(plainDir string, encDir string, freqOuter []byte, opts attackSimOptions) (corpus alignedCorpus, err error) {
+	corpus.TagCounts = make(map[string]int)
+	corpus.WordCounts = make(map[string]int)
+
+	filepaths, err := getFilePathsIn(plainDir)
+	if err != nil {
+		return
+	}
+
+	votes := make(map[string]map[string]int)
+	for _, fp := range filepaths {
+		encPath := path.Join(encDir, path.Base(fp)+".enc")
+		if _, statErr := os.Stat(encPath); statErr != nil {
+			corpus.Skipped += 1
+			continue
+		}
+
+		texts, readErr := readFreeTexts(fp, opts.Format, opts.Schema)
+		if readErr != nil {
+			return corpus, readErr
+		}
+
+		notes, readErr := readEncryptedNotes(encPath, opts.Format, opts.Schema)
+		if readErr != nil {
+			return corpus, readErr
+		}
+
+		if len(texts) != len(notes) {
+			corpus.Skipped += len(texts)
+			continue
+		}
+
+		for i, text := range texts {
+			text, _ = opts.PHI.Scrub(text)
+			words := opts.tokenizer().Split(text)
+			tags := decryptNote(notes[i].Payload, nil, freqOuter, DecryptOptions{MergeMap: opts.MergeMap}).Tags
+
+			if len(words) != len(tags) {
+				corpus.Skipped += 1
+				continue
+			}
+
+			for j, t := range tags {
+				if votes[t] == nil {
+					votes[t] = make(map[string]int)
+				}
+				votes[t][words[j]] += 1
+				corpus.TagCounts[t] += 1
+				corpus.WordCounts[words[j]] += 1
+			}
+
+			corpus.TagDocs = append(corpus.TagDocs, tags)
+			corpus.WordDocs = append(corpus.WordDocs, words)
+		}
+	}
+
+	// a tag's true word is the word it encrypts most often
+	corpus.Truth = make(map[string]string)
+	for t, words := range votes {
+		best := ""
+		for w, n := range words {
+			if best == "" || n > words[best] || (n == words[best] && w < best) {
+				best = w
+			}
+		}
+		corpus.Truth[t] = best
+	}
+
+	return
+}
+
+// readWordDocs returns the tokenized notes of a plaintext corpus
+func readWordDocs(dirpath string, opts attackSimOptions) (docs [][]string, err error) {
+	filepaths, err := getFilePathsIn(dirpath)
+	if err != nil {
+		return
+	}
+
+	for _, fp := range filepaths {
+		texts, readErr := readFreeTexts(fp, opts.Format, opts.Schema)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		for _, text := range texts {
+			text, _ = opts.PHI.Scrub(text)
+			docs = append(docs, opts.tokenizer().Split(text))
+		}
+	}
+
+	return
+}
+
+// readAuxFrequencies reads a word frequency list: one word per line,
+// optionally followed by its count. Without counts, lines are taken to be
+// in decreasing order of frequency.
+func readAuxFrequencies(filepath string) (aux []attack.WordFreq, err error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lines = append(lines, strings.FieldsFunc(line, func(c rune) bool {
+			return c == ',' || unicode.IsSpace(c)
+		}))
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	for i, fields := range lines {
+		count := float64(len(lines) - i)
+		if len(fields) > 1 {
+			if parsed, parseErr := strconv.ParseFloat(fields[1], 64); parseErr == nil {
+				count = parsed
+			}
+		}
+		aux = append(aux, attack.WordFreq{Word: strings.ToLower(fields[0]), Count: count})
+	}
+
+	return
+}
+
+//MARK: Scoring
+
+// tokenClasses are reported in this order
+var tokenClasses = []string{"common", "moderate", "rare", "numeric", "placeholder", "all"}
+
+// tokenClass buckets a plaintext word: PHI placeholders, words with digits,
+// and otherwise by frequency: words seen fewer than 5 times are rare and the
+// 100 most frequent words common.
+func tokenClass(word string, counts map[string]int, common map[string]bool) string {
+	switch {
+	case placeholderPattern.MatchString(word):
+		return "placeholder"
+	case strings.IndexFunc(word, unicode.IsDigit) >= 0:
+		return "numeric"
+	case counts[word] < 5:
+		return "rare"
+	case common[word]:
+		return "common"
+	default:
+		return "moderate"
+	}
+}
+
+// scoreAttack counts, per token class, the tags and tag occurrences whose
+// word the attack guessed right.
+func scoreAttack(name string, corpus alignedCorpus, guesses map[string]string) (results []attackResult) {
+	var words []string
+	for w := range corpus.WordCounts {
+		words = append(words, w)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if corpus.WordCounts[words[i]] != corpus.WordCounts[words[j]] {
+			return corpus.WordCounts[words[i]] > corpus.WordCounts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	common := make(map[string]bool)
+	for i := 0; i < len(words) && i < 100; i++ {
+		common[words[i]] = true
+	}
+
+	byClass := make(map[string]*attackResult)
+	for _, class := range tokenClasses {
+		byClass[class] = &attackResult{Attack: name, Class: class}
+	}
+
+	for t, w := range corpus.Truth {
+		hit := guesses[t] == w
+		for _, class := range []string{tokenClass(w, corpus.WordCounts, common), "all"} {
+			r := byClass[class]
+			r.Tags += 1
+			r.Tokens += corpus.TagCounts[t]
+			if hit {
+				r.RecoveredTags += 1
+				r.RecoveredTokens += corpus.TagCounts[t]
+			}
+		}
+	}
+
+	for _, class := range tokenClasses {
+		r := byClass[class]
+		if r.Tokens > 0 {
+			r.TokenRate = float64(r.RecoveredTokens) / float64(r.Tokens)
+		}
+		results = append(results, *r)
+	}
+
+	return
+}
+
+// runAttacks runs rank matching with the auxiliary frequencies, then the
+// co-occurrence attack seeded with the most frequent rank matches, using
+// the co-occurrence of the auxiliary corpus.
+func runAttacks(corpus alignedCorpus, aux []attack.WordFreq, auxDocs [][]string, opts attackSimOptions) (results []attackResult) {
+	rankGuesses := attack.RankMatch(corpus.TagCounts, aux, opts.Top)
+	results = append(results, scoreAttack("rank", corpus, rankGuesses)...)
+
+	var tags []string
+	for t := range corpus.TagCounts {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if corpus.TagCounts[tags[i]] != corpus.TagCounts[tags[j]] {
+			return corpus.TagCounts[tags[i]] > corpus.TagCounts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+
+	seed := make(map[string]string)
+	for i := 0; i < len(tags) && i < opts.Seeds; i++ {
+		if w, ok := rankGuesses[tags[i]]; ok {
+			seed[tags[i]] = w
+		}
+	}
+
+	coGuesses := attack.CooccurrenceMatch(attack.NewCooccurrence(corpus.TagDocs, opts.Top), attack.NewCooccurrence(auxDocs, opts.Top), seed, opts.Window, opts.Rounds)
+	results = append(results, scoreAttack("cooccurrence", corpus, coGuesses)...)
+
+	return
+}
+
+// writeAttackResults prints the recovery rates as a table, or as JSON
+func writeAttackResults(w io.Writer, results []attackResult, asJSON bool) (err error) {
+	if asJSON {
+		var data []byte
+		data, err = json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return
+		}
+		_, err = w.Write(append(data, '\n'))
+		return
+	}
+
+	fmt.Fprintf(w, "%-13s %-12s %8s %10s %10s %10s %8s\n", "attack", "class", "tags", "recovered", "tokens", "recovered", "rate")
+	for _, r := range results {
+		fmt.Fprintf(w, "%-13s %-12s %8d %10d %10d %10d %7.1f%%\n", r.Attack, r.Class, r.Tags, r.RecoveredTags, r.Tokens, r.RecoveredTokens, 100*r.TokenRate)
+	}
+	return
+}
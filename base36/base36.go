@@ -3,6 +3,7 @@ package base36
 import (
 	"errors"
 	"math/big"
+	"strings"
 )
 
 func Encode(data []byte) (res string, err error) {
@@ -36,3 +37,24 @@ func DecodeString(str string) (data []byte, err error) {
 	data = data[1:]
 	return
 }
+
+// EncodedLen is the length of the longest encoding of n bytes
+func EncodedLen(n int) int {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(8*n+1))
+	max.Sub(max, big.NewInt(1))
+	return len(max.Text(36))
+}
+
+// EncodeFixed left pads the encoding with zeros to EncodedLen, so that all
+// encodings of the same number of bytes have the same length.
+func EncodeFixed(data []byte) (res string, err error) {
+	res, err = Encode(data)
+	if err != nil {
+		return
+	}
+
+	if pad := EncodedLen(len(data)) - len(res); pad > 0 {
+		res = strings.Repeat("0", pad) + res
+	}
+	return
+}
@@ -70,3 +70,38 @@ func TestRandEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeFixed(t *testing.T) {
+	width := EncodedLen(64)
+
+	for i := 0; i < 1000; i++ {
+		s := make([]byte, 64)
+		_, err := rand.Read(s)
+		if err != nil {
+			t.Error("rand error:", err)
+			return
+		}
+
+		es, err := EncodeFixed(s)
+		if err != nil {
+			t.Error("Error:", err)
+			return
+		}
+
+		if len(es) != width {
+			t.Errorf("Encoding has length %d. Expected %d.", len(es), width)
+			return
+		}
+
+		ds, err := DecodeString(es)
+		if err != nil {
+			t.Errorf("Could not decode: %s\n", err)
+			return
+		}
+
+		if string(ds) != string(s) {
+			t.Errorf("Bytes don't match. Got %x. Expected %x.", ds, s)
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/pks"
+)
+
+// keyBundleFormat identifies key bundle files, and is bound to their
+// sealed contents.
+const keyBundleFormat = "alvis-key-bundle"
+
+const keyEnvelopeVersion = 1
+
+// keyEnvelope is a key bundle or key file as written to disk: its contents
+// sealed to the recipient's bundle key or analyst key, identified by
+// Recipient. Ephemeral is the sender's X25519 key, for analyst keys.
+type keyEnvelope struct {
+	Format    string
+	Version   int
+	Recipient string
+	Ephemeral []byte `json:",omitempty"`
+	Sealed    []byte
+}
+
+// keyBundle holds the keys of many keywords. The index lists each keyword,
+// its kind and how many of Keys, in order, belong to it.
+type keyBundle struct {
+	Created string
+	Master  string
+	Index   []keyBundleEntry
+	Keys    []pks.PrivateKey
+}
+
+type keyBundleEntry struct {
+	Keyword string
+	Kind    string
+	Keys    int
+}
+
+// keyID names a symmetric key without revealing it
+func keyID(key []byte) string {
+	return hex.EncodeToString(cryptutil.SHA2(key)[:8])
+}
+
+// Fingerprint names the master key, so bundles tell which corpus their
+// keys search.
+func (msk MasterKey) Fingerprint() string {
+	return hex.EncodeToString(msk.subKey("alvis-fingerprint")[:8])
+}
+
+//MARK: Keyword kinds
+
+// keywordKind is exact, prefix (cardio*) or fuzzy (pneumonia~)
+func keywordKind(word string) string {
+	if _, _, fuzzy := parseFuzzyKeyword(word); fuzzy {
+		return "fuzzy"
+	}
+	if isPrefixKey(pks.PrivateKey{Keyword: word}) {
+		return "prefix"
+	}
+	return "exact"
+}
+
+// extractKeys issues the keys of a keyword of any kind: one key, or a list
+// for fuzzy keywords.
+func extractKeys(master MasterKey, word string) (keys []pks.PrivateKey, err error) {
+	if keywordKind(word) == "fuzzy" {
+		return extractFuzzyKeys(master, word)
+	}
+
+	return []pks.PrivateKey{extractKeywordKey(master, word)}, nil
+}
+
+//MARK: Bundles
+
+func newKeyBundle(master MasterKey, words []string) (bundle keyBundle, err error) {
+	bundle.Created = time.Now().UTC().Format(time.RFC3339)
+	bundle.Master = master.Fingerprint()
+
+	for _, w := range words {
+		keys, extractErr := extractKeys(master, w)
+		if extractErr != nil {
+			err = extractErr
+			return
+		}
+
+		bundle.Index = append(bundle.Index, keyBundleEntry{w, keywordKind(w), len(keys)})
+		bundle.Keys = append(bundle.Keys, keys...)
+	}
+
+	return
+}
+
+// writeKeyBundle seals a bundle to a bundle key or analyst key
+func writeKeyBundle(bundle keyBundle, to envelopeKey, outpath string) (err error) {
+	bundleBytes, err := json.Marshal(bundle)
+	if err != nil {
+		return
+	}
+
+	envelopeBytes, err := sealEnvelope(keyBundleFormat, bundleBytes, to)
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(outpath, envelopeBytes, 0660)
+	return
+}
+
+// readKeyBundle opens a bundle with the bundle key or identity it was
+// sealed to, and checks its index
+func readKeyBundle(inpath string, opener envelopeKey) (bundle keyBundle, err error) {
+	envelopeBytes, err := ioutil.ReadFile(inpath)
+	if err != nil {
+		return
+	}
+
+	envelope, ok := parseEnvelope(envelopeBytes)
+	if !ok || envelope.Format != keyBundleFormat {
+		err = errors.New(fmt.Sprintf("%s is not a key bundle", inpath))
+		return
+	}
+
+	bundleBytes, err := openEnvelope(envelope, keyBundleFormat, opener)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(bundleBytes, &bundle)
+	if err != nil {
+		return
+	}
+
+	indexed := 0
+	for _, entry := range bundle.Index {
+		indexed += entry.Keys
+	}
+	if indexed != len(bundle.Keys) {
+		err = errors.New(fmt.Sprintf("Key bundle index lists %d keys, but it holds %d", indexed, len(bundle.Keys)))
+	}
+
+	return
+}
+
+// readBundleKey reads a bundle key from 'extract bundle-key'
+func readBundleKey(keyPath string) (bundleKey []byte, err error) {
+	bundleKey, err = ioutil.ReadFile(keyPath)
+	if err != nil {
+		return
+	}
+
+	if len(bundleKey) != cryptutil.KeySize/8 {
+		err = errors.New(fmt.Sprintf("%s is not a bundle key", keyPath))
+	}
+	return
+}
+
+// isKeyDir is whether a key path is a directory of key files, rather than
+// a key bundle
+func isKeyDir(keyPath string) bool {
+	fi, err := os.Stat(keyPath)
+	return err != nil || fi.IsDir()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+func TestKeyBundleRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	bundleKey, err := cryptutil.RandKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := newKeyBundle(master, []string{"stemi", "cardio*", "pnuemonia~"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundlePath := path.Join(dir, "keys.akb")
+	err = writeKeyBundle(bundle, envelopeKey{Bundle: bundleKey}, bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := readKeyBundle(bundlePath, envelopeKey{Bundle: bundleKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened.Master != master.Fingerprint() || len(opened.Index) != 3 || len(opened.Keys) != len(bundle.Keys) {
+		t.Fatalf("Unexpected bundle: %v", opened.Index)
+	}
+	for i, kind := range []string{"exact", "prefix", "fuzzy"} {
+		if opened.Index[i].Kind != kind {
+			t.Fatalf("Keyword %s is %s. Expected %s.", opened.Index[i].Keyword, opened.Index[i].Kind, kind)
+		}
+	}
+}
+
+func TestKeyBundleWrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	bundleKey, _ := cryptutil.RandKey()
+	otherKey, _ := cryptutil.RandKey()
+
+	bundle, err := newKeyBundle(master, []string{"stemi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundlePath := path.Join(dir, "keys.akb")
+	err = writeKeyBundle(bundle, envelopeKey{Bundle: bundleKey}, bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = readKeyBundle(bundlePath, envelopeKey{Bundle: otherKey}); err == nil {
+		t.Fatal("Opened a bundle with the wrong bundle key")
+	}
+	if _, err = readKeyBundle(bundlePath, envelopeKey{}); err == nil {
+		t.Fatal("Opened a bundle without a bundle key")
+	}
+
+	// a bundle relabeled for another key still does not open with it
+	envelopeBytes, _ := ioutil.ReadFile(bundlePath)
+	envelope, _ := parseEnvelope(envelopeBytes)
+	envelope.Recipient = keyID(otherKey)
+	if _, err = openEnvelope(envelope, keyBundleFormat, envelopeKey{Bundle: otherKey}); err == nil {
+		t.Fatal("Opened a relabeled bundle with the wrong bundle key")
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// cohortTerm matches the notes of a record type (any, if empty) in which
+// the keyword hits, within the section if given. With Then, the note must be
+// followed by a note matching Then, at most Within days later unless Within
+// is negative.
+type cohortTerm struct {
+	Record  string
+	Section string
+	Keyword string
+
+	Then   *cohortTerm
+	Within int
+}
+
+func (t cohortTerm) String() string {
+	s := t.Keyword
+	if t.Section != "" {
+		s = t.Record + ":" + t.Section + ":" + t.Keyword
+	} else if t.Record != "" {
+		s = t.Record + ":" + t.Keyword
+	}
+
+	if t.Then != nil {
+		s += " THEN " + t.Then.String()
+		if t.Within >= 0 {
+			s += " WITHIN " + strconv.Itoa(t.Within)
+		}
+	}
+	return s
+}
+
+// cohortQuery is an AND of clauses, each an OR of terms, like
+// "Rad:impression:nodule AND (Pat:adenocarcinoma OR Pat:carcinoma)".
+type cohortQuery struct {
+	Clauses [][]cohortTerm
+}
+
+//MARK: Parsing
+
+// cohortKeywords are the reserved words of queries
+var cohortKeywords = map[string]bool{"AND": true, "OR": true, "THEN": true, "WITHIN": true}
+
+// parseCohortQuery parses terms joined by AND and OR, OR binding tighter.
+// Parentheses may group OR terms for readability, but not AND, which
+// would need a different grouping. A term may be followed by "THEN term",
+// optionally with "WITHIN days".
+func parseCohortQuery(query string) (q cohortQuery, err error) {
+	words, err := stripCohortGroups(strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(query)))
+	if err != nil {
+		return
+	}
+	if len(words) == 0 {
+		err = errors.New("Empty cohort query")
+		return
+	}
+
+	var clause []cohortTerm
+	for i := 0; i < len(words); i++ {
+		term, next, termErr := parseCohortSequence(words, i)
+		if termErr != nil {
+			return q, termErr
+		}
+		clause = append(clause, term)
+
+		i = next
+		if i == len(words) {
+			break
+		}
+
+		switch strings.ToUpper(words[i]) {
+		case "AND":
+			q.Clauses = append(q.Clauses, clause)
+			clause = nil
+		case "OR":
+		default:
+			err = errors.New(fmt.Sprintf("Expected AND or OR, got %s", words[i]))
+			return
+		}
+
+		if i == len(words)-1 {
+			err = errors.New("Cohort query ends with an operator")
+			return
+		}
+	}
+
+	q.Clauses = append(q.Clauses, clause)
+	return
+}
+
+// stripCohortGroups drops the parentheses of a query, checking that they
+// balance and only group OR terms, as OR binds tighter than AND anyway.
+func stripCohortGroups(words []string) (stripped []string, err error) {
+	depth := 0
+	for _, w := range words {
+		switch {
+		case w == "(":
+			depth += 1
+		case w == ")":
+			depth -= 1
+			if depth < 0 {
+				err = errors.New("Unbalanced ')' in cohort query")
+				return
+			}
+		case depth > 0 && strings.ToUpper(w) == "AND":
+			err = errors.New("AND cannot be grouped in parentheses: queries are an AND of OR groups, like A AND (B OR C)")
+			return
+		default:
+			stripped = append(stripped, w)
+		}
+	}
+
+	if depth != 0 {
+		err = errors.New("Unbalanced '(' in cohort query")
+	}
+	return
+}
+
+// parseCohortSequence parses the term at words[i] and its THEN and WITHIN
+// parts, returning the index of the word after them.
+func parseCohortSequence(words []string, i int) (term cohortTerm, next int, err error) {
+	term, err = parseCohortTerm(words[i])
+	if err != nil {
+		return
+	}
+
+	next = i + 1
+	if next == len(words) || strings.ToUpper(words[next]) != "THEN" {
+		return
+	}
+
+	if next+1 == len(words) {
+		err = errors.New("THEN must be followed by a term")
+		return
+	}
+
+	then, err := parseCohortTerm(words[next+1])
+	if err != nil {
+		return
+	}
+	term.Then = &then
+	term.Within = -1
+	next += 2
+
+	if next == len(words) || strings.ToUpper(words[next]) != "WITHIN" {
+		return
+	}
+
+	if next+1 == len(words) {
+		err = errors.New("WITHIN must be followed by a number of days")
+		return
+	}
+
+	days, convErr := strconv.Atoi(strings.TrimSuffix(strings.ToLower(words[next+1]), "d"))
+	if convErr != nil || days < 0 {
+		err = errors.New(fmt.Sprintf("Invalid number of days: %s", words[next+1]))
+		return
+	}
+	term.Within = days
+	next += 2
+
+	// "WITHIN 30 DAYS" reads better
+	if next < len(words) && strings.ToUpper(words[next]) == "DAYS" {
+		next += 1
+	}
+
+	return
+}
+
+// parseCohortTerm parses keyword, Record:keyword or Record:section:keyword.
+// The record may be empty to match any.
+func parseCohortTerm(word string) (term cohortTerm, err error) {
+	if cohortKeywords[strings.ToUpper(word)] {
+		err = errors.New(fmt.Sprintf("Expected a term, got %s", word))
+		return
+	}
+
+	parts := strings.SplitN(word, ":", 3)
+	switch len(parts) {
+	case 3:
+		term.Record = parts[0]
+		term.Section = sectionLabel(parts[1])
+		term.Keyword = parts[2]
+	case 2:
+		term.Record = parts[0]
+		term.Keyword = parts[1]
+	default:
+		term.Keyword = parts[0]
+	}
+
+	// tokens are matched lower case
+	term.Keyword = strings.ToLower(term.Keyword)
+	if term.Keyword == "" {
+		err = errors.New(fmt.Sprintf("Missing keyword in term %s", word))
+	}
+	return
+}
+
+// terms are every term of the query, in order
+func (q cohortQuery) terms() (terms []cohortTerm) {
+	for _, clause := range q.Clauses {
+		terms = append(terms, clause...)
+	}
+	return
+}
+
+// keywords are the distinct keywords the query needs keys for
+func (q cohortQuery) keywords() (keywords []string) {
+	seen := make(map[string]bool)
+	for _, t := range q.terms() {
+		for step := &t; step != nil; step = step.Then {
+			if !seen[step.Keyword] {
+				seen[step.Keyword] = true
+				keywords = append(keywords, step.Keyword)
+			}
+		}
+	}
+	return
+}
+
+//MARK: Evaluation
+
+// evaluate matches the notes of one patient against the query. The evidence
+// of each term is the number of notes it matches; for THEN terms, the number
+// of notes followed by a matching note.
+func (q cohortQuery) evaluate(file corpusFile) (match bool, evidence []int, err error) {
+	for _, t := range q.terms() {
+		notes, termErr := termEvidence(file, t)
+		if termErr != nil {
+			return false, nil, termErr
+		}
+		evidence = append(evidence, notes)
+	}
+
+	match = true
+	i := 0
+	for _, clause := range q.Clauses {
+		clauseMatch := false
+		for range clause {
+			if evidence[i] > 0 {
+				clauseMatch = true
+			}
+			i += 1
+		}
+		match = match && clauseMatch
+	}
+
+	return
+}
+
+// affirmedOnly drops the keyword hits that are negated, uncertain or
+// historical. Hits of unknown status, as from payloads without assertions,
+// are kept.
+func affirmedOnly(file corpusFile) corpusFile {
+	notes := make([]decryptedNote, len(file.Notes))
+	for n, note := range file.Notes {
+		notes[n] = note
+		notes[n].Keywords = make([]string, len(note.Keywords))
+		for i, keyword := range note.Keywords {
+			if status := note.Assertions[i]; status == "" || status == assertionAffirmed.String() {
+				notes[n].Keywords[i] = keyword
+			}
+		}
+	}
+
+	file.Notes = notes
+	return file
+}
+
+func termEvidence(file corpusFile, term cohortTerm) (notes int, err error) {
+	for n := range file.Notes {
+		if !noteMatches(file, n, term) {
+			continue
+		}
+
+		if term.Then == nil {
+			notes += 1
+			continue
+		}
+
+		followed, followErr := noteFollowed(file, n, term)
+		if followErr != nil {
+			return 0, followErr
+		}
+		if followed {
+			notes += 1
+		}
+	}
+	return
+}
+
+func noteMatches(file corpusFile, n int, term cohortTerm) bool {
+	if term.Record != "" && file.Records[n] != term.Record {
+		return false
+	}
+
+	note := file.Notes[n]
+	for i, keyword := range note.Keywords {
+		if keyword == term.Keyword && (term.Section == "" || note.Sections[i] == term.Section) {
+			return true
+		}
+	}
+	return false
+}
+
+// noteFollowed is whether another note matching term.Then is dated on or
+// after note n, within term.Within days. Distances between ORE encrypted
+// dates are unknown, so WITHIN needs plaintext or shifted note dates.
+func noteFollowed(file corpusFile, n int, term cohortTerm) (followed bool, err error) {
+	for m := range file.Notes {
+		if m == n || !noteMatches(file, m, *term.Then) {
+			continue
+		}
+
+		days, exact, ok := file.Dates[n].daysUntil(file.Dates[m])
+		if !ok || days < 0 {
+			continue
+		}
+
+		if term.Within >= 0 {
+			if !exact {
+				err = errors.New("WITHIN needs plaintext or shifted note dates: ORE encrypted dates only reveal order")
+				return
+			}
+			if days > term.Within {
+				continue
+			}
+		}
+
+		return true, nil
+	}
+	return
+}
+
+//MARK: Output
+
+// cohortWriter writes a CSV row per matching patient, with the evidence of
+// each term.
+type cohortWriter struct {
+	writer *csv.Writer
+}
+
+func newCohortWriter(w io.Writer, q cohortQuery) (cw cohortWriter, err error) {
+	cw.writer = csv.NewWriter(w)
+
+	header := []string{"file", "patient"}
+	for _, t := range q.terms() {
+		header = append(header, t.String())
+	}
+
+	err = cw.writer.Write(header)
+	return
+}
+
+func (cw cohortWriter) write(file corpusFile, evidence []int) (err error) {
+	row := []string{path.Base(file.Path), file.patient()}
+	for _, e := range evidence {
+		row = append(row, strconv.Itoa(e))
+	}
+
+	err = cw.writer.Write(row)
+	if err != nil {
+		return
+	}
+
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
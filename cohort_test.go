@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+)
+
+func testCohortFile(patients []string, records []string, days []int, keywords []string) (file corpusFile) {
+	for n := range records {
+		file.Patients = append(file.Patients, patients[n])
+		file.Records = append(file.Records, records[n])
+		file.Dates = append(file.Dates, noteDate{Valid: true, Day: days[n]})
+		file.Notes = append(file.Notes, decryptedNote{Keywords: []string{"", keywords[n]}, Sections: []string{"", ""}})
+	}
+	return
+}
+
+func TestParseCohortQueryGroups(t *testing.T) {
+	q, err := parseCohortQuery("Rad:nodule AND (Pat:adenocarcinoma OR Pat:carcinoma)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Clauses) != 2 || len(q.Clauses[1]) != 2 {
+		t.Fatalf("Unexpected clauses: %v", q.Clauses)
+	}
+
+	for _, query := range []string{"(Rad:nodule AND Pat:carcinoma) OR Dis:stemi", "(Rad:nodule OR Pat:carcinoma", "Rad:nodule)"} {
+		if _, err := parseCohortQuery(query); err == nil {
+			t.Fatalf("Expected an error for %s", query)
+		}
+	}
+}
+
+func TestCohortThenWithin(t *testing.T) {
+	file := testCohortFile([]string{"p", "p"}, []string{"Rad", "Pat"}, []int{100, 120}, []string{"nodule", "carcinoma"})
+
+	cases := map[string]bool{
+		"Rad:nodule THEN Pat:carcinoma":                    true,
+		"Rad:nodule THEN Pat:carcinoma WITHIN 30 DAYS":     true,
+		"Rad:nodule THEN Pat:carcinoma WITHIN 10":          false,
+		"Pat:carcinoma THEN Rad:nodule":                    false,
+		"Rad:nodule THEN Rad:nodule":                       false,
+		"Rad:carcinoma OR Pat:carcinoma THEN Pat:nodule":   false,
+		"Rad:carcinoma OR (Rad:nodule THEN Pat:carcinoma)": true,
+	}
+
+	for query, expected := range cases {
+		q, err := parseCohortQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		match, _, err := q.evaluate(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match != expected {
+			t.Fatalf("%s matched %v, expected %v", query, match, expected)
+		}
+	}
+}
+
+func TestCohortPatientsOfOneFile(t *testing.T) {
+	file := testCohortFile([]string{"a", "b", "b"}, []string{"Rad", "Pat", "Rad"}, []int{1, 2, 3}, []string{"nodule", "carcinoma", "effusion"})
+
+	q, err := parseCohortQuery("Rad:nodule AND Pat:carcinoma")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patients := file.patients()
+	if len(patients) != 2 || patients[0].patient() != "a" || len(patients[1].Notes) != 2 {
+		t.Fatalf("Unexpected patients: %v", patients)
+	}
+
+	for _, patient := range patients {
+		if match, _, _ := q.evaluate(patient); match {
+			t.Fatalf("Patient %s matched with another patient's notes", patient.patient())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// cooccurrence counts the pairs of items (revealed keywords, else frequency
+// tags) seen within a window of each other in the notes of a corpus.
+type cooccurrence struct {
+	Window   int
+	Pairs    map[[2]string]int
+	Marginal map[string]int
+	Total    int
+}
+
+// collocation is one pair of items with its window count and association
+type collocation struct {
+	A     string
+	B     string
+	Count int
+	PMI   float64
+	NPMI  float64
+}
+
+func newCooccurrence(window int) *cooccurrence {
+	return &cooccurrence{
+		Window:   window,
+		Pairs:    make(map[[2]string]int),
+		Marginal: make(map[string]int),
+	}
+}
+
+// noteItems are the tokens of a note, keywords standing in for their tags
+func noteItems(note decryptedNote) (items []string) {
+	for i, t := range note.Tags {
+		if note.Keywords[i] != "" {
+			items = append(items, note.Keywords[i])
+		} else {
+			items = append(items, t)
+		}
+	}
+	return
+}
+
+//MARK: Counting
+
+// addNote counts every pair of tokens at most Window tokens apart
+func (c *cooccurrence) addNote(note decryptedNote) {
+	items := noteItems(note)
+	for i := range items {
+		for j := i + 1; j < len(items) && j <= i+c.Window; j++ {
+			a, b := items[i], items[j]
+			if b < a {
+				a, b = b, a
+			}
+
+			c.Pairs[[2]string{a, b}] += 1
+			c.Marginal[a] += 1
+			c.Marginal[b] += 1
+			c.Total += 1
+		}
+	}
+}
+
+// collocations scores the pairs seen at least minCount times. With focus
+// items, only pairs involving one of them are kept. PMI treats every window
+// pair as two ordered observations, so p(a) sums to one over the items.
+func (c *cooccurrence) collocations(minCount int, focus []string) (result []collocation) {
+	focused := make(map[string]bool)
+	for _, f := range focus {
+		focused[f] = true
+	}
+
+	slots := float64(2 * c.Total)
+	for pair, count := range c.Pairs {
+		if count < minCount {
+			continue
+		}
+		if len(focused) > 0 && !focused[pair[0]] && !focused[pair[1]] {
+			continue
+		}
+
+		ordered := float64(count)
+		if pair[0] == pair[1] {
+			ordered *= 2
+		}
+
+		pJoint := ordered / slots
+		pA := float64(c.Marginal[pair[0]]) / slots
+		pB := float64(c.Marginal[pair[1]]) / slots
+
+		pmi := math.Log(pJoint / (pA * pB))
+		npmi := 1.0
+		if pJoint < 1 {
+			npmi = pmi / -math.Log(pJoint)
+		}
+
+		result = append(result, collocation{pair[0], pair[1], count, pmi, npmi})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		if result[i].A != result[j].A {
+			return result[i].A < result[j].A
+		}
+		return result[i].B < result[j].B
+	})
+
+	return
+}
+
+func writeCollocations(w io.Writer, result []collocation) (err error) {
+	writer := csv.NewWriter(w)
+
+	err = writer.Write([]string{"a", "b", "count", "pmi", "npmi"})
+	if err != nil {
+		return
+	}
+
+	for _, col := range result {
+		err = writer.Write([]string{
+			col.A,
+			col.B,
+			strconv.Itoa(col.Count),
+			strconv.FormatFloat(col.PMI, 'f', 4, 64),
+			strconv.FormatFloat(col.NPMI, 'f', 4, 64),
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
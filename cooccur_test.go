@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+func TestCooccurrenceWindow(t *testing.T) {
+	c := newCooccurrence(2)
+	c.addNote(decryptedNote{Tags: []string{"x", "y", "z", "w"}, Keywords: make([]string, 4)})
+
+	// pairs at most 2 tokens apart, in either order
+	for _, pair := range [][2]string{{"x", "y"}, {"x", "z"}, {"y", "z"}, {"w", "y"}, {"w", "z"}} {
+		if c.Pairs[pair] != 1 {
+			t.Errorf("Pair %v counted %d times", pair, c.Pairs[pair])
+		}
+	}
+	if c.Total != 5 || c.Pairs[[2]string{"w", "x"}] != 0 || c.Pairs[[2]string{"y", "x"}] != 0 {
+		t.Fatalf("Unexpected pairs: %v", c.Pairs)
+	}
+
+	// a token is not paired with itself, only with repeats of it
+	c = newCooccurrence(2)
+	c.addNote(decryptedNote{Tags: []string{"x"}, Keywords: make([]string, 1)})
+	c.addNote(decryptedNote{Tags: []string{"a", "a"}, Keywords: make([]string, 2)})
+	if c.Total != 1 || c.Pairs[[2]string{"a", "a"}] != 1 || c.Marginal["a"] != 2 {
+		t.Fatalf("Unexpected pairs: %v", c.Pairs)
+	}
+}
+
+func TestCooccurrenceKeywords(t *testing.T) {
+	master := testMasterKey(t)
+
+	payload, err := encryptFreeText(master, EncryptOptions{}, noteContext{}, "acute stemi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a revealed keyword stands in for its tag
+	note := decryptNote(payload, []pks.PrivateKey{master.KeywordKey.Extract("stemi")}, master.FrequencyKey.OuterKey, DecryptOptions{})
+	c := newCooccurrence(1)
+	c.addNote(note)
+
+	pair := [2]string{tagOf(master, "acute"), "stemi"}
+	if pair[1] < pair[0] {
+		pair[0], pair[1] = pair[1], pair[0]
+	}
+	if len(c.Pairs) != 1 || c.Pairs[pair] != 1 {
+		t.Fatalf("Unexpected pairs: %v", c.Pairs)
+	}
+}
+
+func TestCollocationPMI(t *testing.T) {
+	c := newCooccurrence(2)
+	c.addNote(decryptedNote{Tags: []string{"x", "y", "z", "w"}, Keywords: make([]string, 4)})
+
+	// 10 ordered slots: p(x, y) = 1/10, p(x) = 2/10, p(y) = 3/10
+	result := c.collocations(1, []string{"x"})
+	if len(result) != 2 || result[0].A != "x" || result[0].B != "y" {
+		t.Fatalf("Unexpected collocations: %v", result)
+	}
+
+	pmi := math.Log(0.1 / (0.2 * 0.3))
+	if math.Abs(result[0].PMI-pmi) > 1e-9 || math.Abs(result[0].NPMI-pmi/-math.Log(0.1)) > 1e-9 {
+		t.Fatalf("PMI %f and NPMI %f, expected %f and %f", result[0].PMI, result[0].NPMI, pmi, pmi/-math.Log(0.1))
+	}
+
+	if result := c.collocations(2, nil); len(result) != 0 {
+		t.Fatalf("Unexpected collocations seen twice: %v", result)
+	}
+
+	var out bytes.Buffer
+	if err := writeCollocations(&out, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "a,b,count,pmi,npmi\nx,y,1,0.5108,0.2218\n") {
+		t.Fatalf("Unexpected CSV: %q", out.String())
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+// encryptedNote is the payload of one encrypted note and the record type it
+// belongs to: the record type of patient files, the resource type of FHIR
+// resources, the column of tabular files and OBX for HL7. Date is the raw
+// value of the note's date field, if any. Patient identifies its patient
+// within the file, as the patient column of tabular files or the PID of
+// HL7 messages, which may be deterministically encrypted.
+type encryptedNote struct {
+	Record  string
+	Payload map[string]interface{}
+	Date    string
+	Patient string
+}
+
+// lookupNoteDate returns the first note date field found in a JSON note or
+// FHIR resource.
+func lookupNoteDate(node map[string]interface{}, fields []string) string {
+	for _, f := range fields {
+		if value, ok := lookupField(node, strings.Split(f, ".")); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+//MARK: Corpus readers
+
+// readEncryptedNotes returns every encrypted note of a data file
+func readEncryptedNotes(inpath string, format string, schema Schema) (notes []encryptedNote, err error) {
+	switch format {
+	case "csv":
+		header, rows, readErr := readTableFile(inpath, schema)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		textCols, colErr := columnIndexes(header, schema.TextColumns)
+		if colErr != nil {
+			return nil, colErr
+		}
+
+		dateCol, colErr := noteDateColumnIndex(header, schema)
+		if colErr != nil {
+			return nil, colErr
+		}
+
+		patientCol, colErr := patientColumnIndex(header, schema)
+		if colErr != nil {
+			return nil, colErr
+		}
+
+		for _, row := range rows {
+			date := ""
+			if dateCol >= 0 {
+				date = row[dateCol]
+			}
+
+			patient := defaultPatientID(inpath)
+			if patientCol >= 0 {
+				patient = row[patientCol]
+			}
+
+			for _, i := range textCols {
+				payload, decodeErr := decodeCompactPayload(row[i])
+				if decodeErr != nil {
+					return nil, decodeErr
+				}
+				notes = append(notes, encryptedNote{header[i], payload, date, patient})
+			}
+		}
+
+	case "fhir":
+		bundle, readErr := readPatientFile(inpath)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		patient := documentPatientID(bundle, schema.PatientField, inpath)
+		dateFields := schema.noteDateFields("fhir")
+		err = walkFHIRResources(bundle, func(resource map[string]interface{}) error {
+			record, _ := resource["resourceType"].(string)
+			date := lookupNoteDate(resource, dateFields)
+
+			for _, attachment := range fhirAttachments(resource) {
+				if !isPayloadAttachment(attachment) {
+					continue
+				}
+
+				payload, decodeErr := attachmentPayload(attachment)
+				if decodeErr != nil {
+					return decodeErr
+				}
+				notes = append(notes, encryptedNote{record, payload, date, patient})
+			}
+
+			if cell, ok := fhirValueString(resource); ok {
+				payload, decodeErr := decodeCompactPayload(cell)
+				if decodeErr != nil {
+					return decodeErr
+				}
+				notes = append(notes, encryptedNote{record, payload, date, patient})
+			}
+			return nil
+		})
+
+	case "hl7":
+		segments, _, readErr := readHL7File(inpath)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		dateRefs, refErr := parseHL7FieldRefs(schema.noteDateFields("hl7"))
+		if refErr != nil {
+			return nil, refErr
+		}
+
+		for _, message := range hl7Messages(segments) {
+			patient := hl7MessagePatient(message, schema.hl7PatientField(), inpath)
+			err = applyCryptorToHL7Notes(message, func(segment int, cell string) (string, error) {
+				payload, decodeErr := decodeCompactPayload(cell)
+				if decodeErr != nil {
+					return "", decodeErr
+				}
+
+				date, _ := hl7SegmentField(message, segment, dateRefs)
+				notes = append(notes, encryptedNote{"OBX", payload, date, patient})
+				return cell, nil
+			})
+			if err != nil {
+				return
+			}
+		}
+
+	default:
+		doc, readErr := readPatientFile(inpath)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		patient := documentPatientID(doc, schema.PatientField, inpath)
+		dateFields := schema.noteDateFields("json")
+		for _, record := range recordTypes {
+			records, _ := doc[record].([]interface{})
+			for _, r := range records {
+				note, _ := r.(map[string]interface{})
+				if payload, ok := note["free_text"].(map[string]interface{}); ok {
+					notes = append(notes, encryptedNote{record, payload, lookupNoteDate(note, dateFields), patient})
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// corpusFile is the decryption of every note of one encrypted file, and the
+// patient of each
+type corpusFile struct {
+	Path     string
+	Records  []string
+	Dates    []noteDate
+	Patients []string
+	Notes    []decryptedNote
+}
+
+// patients splits a file into the notes of each of its patients, in order
+// of first appearance, for tabular and HL7 files holding many.
+func (f corpusFile) patients() (files []corpusFile) {
+	index := make(map[string]int)
+	for n := range f.Notes {
+		i, ok := index[f.Patients[n]]
+		if !ok {
+			i = len(files)
+			index[f.Patients[n]] = i
+			files = append(files, corpusFile{Path: f.Path})
+		}
+
+		files[i].Records = append(files[i].Records, f.Records[n])
+		files[i].Dates = append(files[i].Dates, f.Dates[n])
+		files[i].Patients = append(files[i].Patients, f.Patients[n])
+		files[i].Notes = append(files[i].Notes, f.Notes[n])
+	}
+
+	return
+}
+
+// patient is the patient of a file split by patients
+func (f corpusFile) patient() string {
+	if len(f.Patients) == 0 {
+		return ""
+	}
+	return f.Patients[0]
+}
+
+// decryptCorpus reads and decrypts every note of every file in a directory
+// of encrypted files, calling fn once per file.
+func decryptCorpus(dirpath string, format string, schema Schema, keywordKeys []pks.PrivateKey, freqOuter []byte, opts DecryptOptions, fn func(corpusFile) error) (err error) {
+	filepaths, err := getFilePathsIn(dirpath)
+	if err != nil {
+		return
+	}
+
+	for _, fp := range filepaths {
+		notes, readErr := readEncryptedNotes(fp, format, schema)
+		if readErr != nil {
+			return readErr
+		}
+
+		file := corpusFile{Path: fp}
+		for _, n := range notes {
+			file.Records = append(file.Records, n.Record)
+			file.Dates = append(file.Dates, parseNoteDate(n.Date))
+			file.Patients = append(file.Patients, n.Patient)
+			file.Notes = append(file.Notes, decryptNote(n.Payload, keywordKeys, freqOuter, opts))
+		}
+
+		err = fn(file)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -76,6 +76,52 @@ func AESDecryptWithIV(key []byte, iv []byte, cipherText []byte) (result []byte,
 	return
 }
 
+//MARK: Authenticated Encryption
+
+// Seal encrypts and authenticates a message with AES-GCM, binding it to the
+// additional data. The random nonce is prepended.
+func Seal(key []byte, message []byte, additional []byte) (result []byte, err error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+
+	result = aead.Seal(nonce, nonce, message, additional)
+	return
+}
+
+// Open decrypts a sealed message, failing if it or the additional data
+// was tampered with or the key is wrong.
+func Open(key []byte, sealed []byte, additional []byte) (result []byte, err error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		err = errors.New("Sealed message shorter than nonce")
+		return
+	}
+
+	nonce := sealed[:aead.NonceSize()]
+	return aead.Open(nil, nonce, sealed[aead.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
 //MARK: Randomness Gen
 func RandIV() (iv []byte, err error) {
 	iv = make([]byte, aes.BlockSize)
@@ -120,3 +166,31 @@ func UnPKCS7Padding(data []byte) (result []byte, err error) {
 
 	return
 }
+
+//MARK: Bucket Padding
+
+// PadToBucket pads data with 0x80 then zeros up to the next multiple of
+// bucket bytes, so padded messages only reveal which bucket they fall in.
+func PadToBucket(data []byte, bucket int) []byte {
+	padding := bucket - len(data)%bucket
+	padded := make([]byte, len(data)+padding)
+	copy(padded, data)
+	padded[len(data)] = 0x80
+	return padded
+}
+
+func UnPadBucket(data []byte) (result []byte, err error) {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0x00:
+			continue
+		case 0x80:
+			result = data[:i]
+			return
+		}
+		break
+	}
+
+	err = errors.New("Invalid bucket padding")
+	return
+}
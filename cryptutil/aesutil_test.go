@@ -0,0 +1,63 @@
+package cryptutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBucketPadding(t *testing.T) {
+	short := PadToBucket([]byte("mi"), 32)
+	long := PadToBucket([]byte("cardiomyopathy"), 32)
+
+	if len(short) != 32 || len(long) != 32 {
+		t.Errorf("Padded lengths %d, %d. Expected 32.", len(short), len(long))
+	}
+
+	for _, word := range []string{"", "mi", "cardiomyopathy", "supercalifragilisticexpialidocious"} {
+		out, err := UnPadBucket(PadToBucket([]byte(word), 32))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if !bytes.Equal(out, []byte(word)) {
+			t.Errorf("Unpadded does not match.\nGot: %s\nExpected: %s", out, word)
+		}
+	}
+
+	if _, err := UnPadBucket([]byte("no padding")); err == nil {
+		t.Error("Error: unpadded data without padding")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	key, _ := RandKey()
+	message := []byte("keyword keys")
+
+	sealed, err := Seal(key, message, []byte("bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := Open(key, sealed, []byte("bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, message) {
+		t.Errorf("Opened does not match.\nGot: %s\nExpected: %s", opened, message)
+	}
+
+	if _, err := Open(key, sealed, []byte("other")); err == nil {
+		t.Error("Error: opened with the wrong additional data")
+	}
+
+	otherKey, _ := RandKey()
+	if _, err := Open(otherKey, sealed, []byte("bundle")); err == nil {
+		t.Error("Error: opened with the wrong key")
+	}
+
+	sealed[len(sealed)-1] ^= 0x01
+	if _, err := Open(key, sealed, []byte("bundle")); err == nil {
+		t.Error("Error: opened a tampered message")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/ore"
+
+	"github.com/fatih/color"
+)
+
+// maxDateShift bounds the per-patient date offset, in days
+const maxDateShift = 365
+
+type datePattern struct {
+	pattern *regexp.Regexp
+	layouts []string
+}
+
+// textDatePatterns are the date formats recognized inside free text
+var textDatePatterns = []datePattern{
+	{regexp.MustCompile(`\b\d{4}-\d{1,2}-\d{1,2}\b`), []string{"2006-01-02", "2006-1-2"}},
+	{regexp.MustCompile(`\b\d{1,2}/\d{1,2}/\d{4}\b`), []string{"01/02/2006", "1/2/2006"}},
+	{regexp.MustCompile(`\b\d{1,2}/\d{1,2}/\d{2}\b`), []string{"01/02/06", "1/2/06"}},
+}
+
+// fieldDateLayouts are the date formats accepted in structured date fields,
+// including FHIR dateTimes and HL7 timestamps.
+var fieldDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"01/02/2006",
+	"1/2/2006",
+	"20060102",
+	"200601021504",
+	"20060102150405",
+}
+
+// dateShifter moves every date of one patient by the same secret offset, or
+// coarsens them into month or year buckets. A nil dateShifter leaves dates
+// untouched.
+type dateShifter struct {
+	Mode   string
+	Bucket string
+	Offset int
+}
+
+// newDateShifter returns the date pass for a patient, or nil if dates are
+// not being de-identified.
+func newDateShifter(master MasterKey, opts EncryptOptions, patient string) *dateShifter {
+	switch opts.DateMode {
+	case "shift":
+		return &dateShifter{Mode: "shift", Offset: dateOffset(master, patient)}
+	case "bucket":
+		return &dateShifter{Mode: "bucket", Bucket: opts.DateBucket}
+	}
+
+	return nil
+}
+
+// dateOffset derives a patient's nonzero shift in days from the master key,
+// so only the master key holder can reverse it.
+func dateOffset(master MasterKey, patient string) int {
+	h := cryptutil.H([]byte(patient), master.subKey("alvis-date"))
+	offset := int(binary.BigEndian.Uint32(h)%(2*maxDateShift+1)) - maxDateShift
+	if offset == 0 {
+		offset = maxDateShift
+	}
+
+	return offset
+}
+
+// inverse undoes a shift. Buckets cannot be undone.
+func (d *dateShifter) inverse() *dateShifter {
+	if d == nil || d.Mode != "shift" {
+		return nil
+	}
+
+	return &dateShifter{Mode: "shift", Offset: -d.Offset}
+}
+
+func (d *dateShifter) apply(t time.Time) time.Time {
+	if d.Mode == "shift" {
+		return t.AddDate(0, 0, d.Offset)
+	}
+
+	if d.Bucket == "year" {
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// Text shifts every recognizable date in free text, keeping each date's
+// format. This is one-way: the shifted dates are encrypted as tokens, so
+// unlike date fields they cannot be unshifted on decrypt or uncover.
+func (d *dateShifter) Text(text string) string {
+	if d == nil {
+		return text
+	}
+
+	for _, dp := range textDatePatterns {
+		text = dp.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			for _, layout := range dp.layouts {
+				t, err := time.Parse(layout, match)
+				if err == nil {
+					return d.apply(t).Format(layout)
+				}
+			}
+			return match
+		})
+	}
+
+	return text
+}
+
+// Field shifts a structured date value, keeping its format.
+func (d *dateShifter) Field(value string) (string, error) {
+	if d == nil || value == "" {
+		return value, nil
+	}
+
+	t, layout, ok := parseFieldDate(value)
+	if !ok {
+		color.Red("Cannot parse date field: %s", value)
+		return value, nil
+	}
+
+	return d.apply(t).Format(layout), nil
+}
+
+func parseFieldDate(value string) (t time.Time, layout string, ok bool) {
+	for _, layout = range fieldDateLayouts {
+		var err error
+		t, err = time.Parse(layout, value)
+		if err == nil {
+			return t, layout, true
+		}
+	}
+
+	return
+}
+
+//MARK: Note dates
+
+// orePrefix marks note dates encrypted with order-revealing encryption
+const orePrefix = "ore:"
+
+// noteDate is the date of a note: a day number for plaintext or shifted
+// dates, or an ORE ciphertext that only reveals order.
+type noteDate struct {
+	Valid bool
+	Day   int
+	ORE   []byte
+}
+
+func epochDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+}
+
+// oreDates encrypts note date fields to ORE ciphertexts of their day, under
+// a key derived from the master key.
+func oreDates(master MasterKey) func(string) (string, error) {
+	key := master.subKey("alvis-ore")
+
+	return func(value string) (string, error) {
+		if value == "" || strings.HasPrefix(value, orePrefix) {
+			return value, nil
+		}
+
+		t, _, ok := parseFieldDate(value)
+		if !ok {
+			color.Red("Cannot parse date field: %s", value)
+			return value, nil
+		}
+
+		// days before 1970 are negative, so offset them into the domain
+		ctxt := ore.Encrypt(key, uint32(int64(epochDay(t))+1<<31))
+
+		encoded, err := base36.EncodeFixed(ctxt)
+		return orePrefix + encoded, err
+	}
+}
+
+// parseNoteDate reads a plaintext, shifted or ORE encrypted note date
+func parseNoteDate(value string) (date noteDate) {
+	if strings.HasPrefix(value, orePrefix) {
+		ctxt, err := base36.DecodeString(strings.TrimPrefix(value, orePrefix))
+		if err == nil && len(ctxt) == ore.Bits {
+			date.Valid = true
+			date.ORE = ctxt
+		}
+		return
+	}
+
+	if t, _, ok := parseFieldDate(value); ok {
+		date.Valid = true
+		date.Day = epochDay(t)
+	}
+
+	return
+}
+
+// daysUntil is the number of days from a to b. For ORE dates only its sign
+// is known, and exact is false. ok is false if the dates cannot be compared.
+func (a noteDate) daysUntil(b noteDate) (days int, exact bool, ok bool) {
+	if !a.Valid || !b.Valid || (a.ORE == nil) != (b.ORE == nil) {
+		return
+	}
+
+	if a.ORE == nil {
+		return b.Day - a.Day, true, true
+	}
+
+	order, err := ore.Compare(b.ORE, a.ORE)
+	return order, false, err == nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import "testing"
+
+func TestDateShiftPerPatient(t *testing.T) {
+	master := testMasterKey(t)
+	opts := EncryptOptions{DateMode: "shift"}
+
+	// every note of a patient moves by the same offset
+	shifter := newDateShifter(master, opts, "p1")
+	if again := newDateShifter(master, opts, "p1"); again.Offset != shifter.Offset {
+		t.Fatalf("Offsets %d and %d of the same patient differ", shifter.Offset, again.Offset)
+	}
+	if shifter.Offset == 0 || shifter.Offset < -maxDateShift || shifter.Offset > maxDateShift {
+		t.Fatalf("Unexpected offset: %d", shifter.Offset)
+	}
+
+	field, err := shifter.Field("2012-03-04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := shifter.Text("seen on 2012-03-04."); text != "seen on "+field+"." {
+		t.Fatalf("Text shifted to %q, field to %s", text, field)
+	}
+
+	// the field shift is undone, keeping the format
+	if hl7, _ := shifter.Field("20120304"); hl7 == "20120304" || len(hl7) != 8 {
+		t.Fatalf("Unexpected HL7 date: %s", hl7)
+	}
+	if unshifted, _ := shifter.inverse().Field(field); unshifted != "2012-03-04" {
+		t.Fatalf("Unshifted to %s", unshifted)
+	}
+
+	// offsets are per patient
+	offsets := make(map[int]bool)
+	for _, patient := range []string{"p1", "p2", "p3", "p4"} {
+		offsets[newDateShifter(master, opts, patient).Offset] = true
+	}
+	if len(offsets) == 1 {
+		t.Fatal("Every patient has the same offset")
+	}
+}
+
+func TestDateBuckets(t *testing.T) {
+	master := testMasterKey(t)
+
+	cases := []struct {
+		Bucket   string
+		Value    string
+		Expected string
+	}{
+		{"month", "2012-03-31", "2012-03-01"},
+		{"month", "2012-03-01", "2012-03-01"},
+		{"year", "2012-12-31", "2012-01-01"},
+		{"year", "20121231", "20120101"},
+	}
+
+	for _, c := range cases {
+		shifter := newDateShifter(master, EncryptOptions{DateMode: "bucket", DateBucket: c.Bucket}, "p1")
+		if bucketed, _ := shifter.Field(c.Value); bucketed != c.Expected {
+			t.Errorf("%s bucket of %s is %s. Expected %s.", c.Bucket, c.Value, bucketed, c.Expected)
+		}
+	}
+
+	shifter := newDateShifter(master, EncryptOptions{DateMode: "bucket", DateBucket: "month"}, "p1")
+	if text := shifter.Text("seen 3/31/2012"); text != "seen 3/1/2012" {
+		t.Fatalf("Text bucketed to %q", text)
+	}
+
+	// buckets cannot be undone
+	if shifter.inverse() != nil {
+		t.Fatal("Bucketed dates have an inverse")
+	}
+	if newDateShifter(master, EncryptOptions{}, "p1") != nil {
+		t.Fatal("Dates shifted without a date mode")
+	}
+}
+
+func TestOREDates(t *testing.T) {
+	encryptDate := oreDates(testMasterKey(t))
+
+	var dates []noteDate
+	for _, value := range []string{"2012-01-01", "2012-01-21", "2012-01-01"} {
+		ctxt, err := encryptDate(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		date := parseNoteDate(ctxt)
+		if !date.Valid || date.ORE == nil {
+			t.Fatalf("Cannot parse ORE date: %s", ctxt)
+		}
+		dates = append(dates, date)
+	}
+
+	// only the order of ORE dates is known
+	if days, exact, ok := dates[0].daysUntil(dates[1]); !ok || exact || days != 1 {
+		t.Fatalf("Unexpected comparison: %d, %v, %v", days, exact, ok)
+	}
+	if days, _, ok := dates[1].daysUntil(dates[0]); !ok || days != -1 {
+		t.Fatalf("Unexpected comparison: %d, %v", days, ok)
+	}
+	if days, _, ok := dates[0].daysUntil(dates[2]); !ok || days != 0 {
+		t.Fatalf("Unexpected comparison: %d, %v", days, ok)
+	}
+
+	// ORE and plaintext dates do not compare
+	if _, _, ok := dates[0].daysUntil(parseNoteDate("2012-01-21")); ok {
+		t.Fatal("Compared an ORE date with a plaintext date")
+	}
+
+	if days, exact, ok := parseNoteDate("2012-01-01").daysUntil(parseNoteDate("2012-01-21")); !ok || !exact || days != 20 {
+		t.Fatalf("Unexpected comparison: %d, %v, %v", days, exact, ok)
+	}
+}
+
+func TestWithinNeedsPlainDates(t *testing.T) {
+	file := testCohortFile([]string{"p", "p"}, []string{"Rad", "Pat"}, []int{100, 120}, []string{"nodule", "carcinoma"})
+	q, err := parseCohortQuery("Rad:nodule THEN Pat:carcinoma WITHIN 30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if match, _, err := q.evaluate(file); !match || err != nil {
+		t.Fatalf("Expected plaintext dates 20 days apart to match: %v", err)
+	}
+
+	encryptDate := oreDates(testMasterKey(t))
+	for n, value := range []string{"2012-01-01", "2012-01-21"} {
+		ctxt, _ := encryptDate(value)
+		file.Dates[n] = parseNoteDate(ctxt)
+	}
+
+	if _, _, err := q.evaluate(file); err == nil {
+		t.Fatal("Expected WITHIN to fail on ORE dates")
+	}
+}
@@ -0,0 +1,51 @@
+package dfe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+var ivLabel = []byte("alvis-dfe-iv")
+
+//MARK: Deterministic Field Encryption Methods
+
+// Encrypt deterministically encrypts a message: the IV is derived from the
+// message itself, so equal plaintexts under the same key give equal
+// ciphertexts and can still be joined on.
+func Encrypt(key []byte, message []byte) (result []byte, err error) {
+	iv := syntheticIV(key, message)
+
+	encryptedMessage, err := cryptutil.AESEncryptWithIV(key, iv, message)
+	if err != nil {
+		return
+	}
+
+	result = make([]byte, len(iv)+len(encryptedMessage))
+	copy(result[0:len(iv)], iv)
+	copy(result[len(iv):], encryptedMessage)
+
+	return
+}
+
+// Decrypt reverses Encrypt and checks that the ciphertext was produced
+// under the same key.
+func Decrypt(key []byte, ciphertext []byte) (result []byte, err error) {
+	result, err = cryptutil.AESDecrypt(key, ciphertext)
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(ciphertext[:aes.BlockSize], syntheticIV(key, result)) {
+		result = nil
+		err = errors.New("Invalid deterministic ciphertext")
+	}
+
+	return
+}
+
+func syntheticIV(key []byte, message []byte) []byte {
+	return cryptutil.H(message, cryptutil.H(ivLabel, key))[:aes.BlockSize]
+}
@@ -0,0 +1,66 @@
+package dfe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+var mrn = []byte("MRN-00012345")
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := cryptutil.RandKey()
+
+	c, err := Encrypt(key, mrn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, err := Decrypt(key, c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(out, mrn) {
+		t.Errorf("Output does not match orginal message.\nGot: %s\nExpected: %s", out, mrn)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	key, _ := cryptutil.RandKey()
+
+	c1, _ := Encrypt(key, mrn)
+	c2, _ := Encrypt(key, mrn)
+	if !bytes.Equal(c1, c2) {
+		t.Error("Error: equal plaintexts gave different ciphertexts")
+	}
+
+	c3, _ := Encrypt(key, []byte("MRN-00012346"))
+	if bytes.Equal(c1, c3) {
+		t.Error("Error: different plaintexts gave equal ciphertexts")
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	key, _ := cryptutil.RandKey()
+	other, _ := cryptutil.RandKey()
+
+	c, _ := Encrypt(key, mrn)
+	if _, err := Decrypt(other, c); err == nil {
+		t.Error("Error: decrypted under the wrong key")
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	key, _ := cryptutil.RandKey()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			Encrypt(key, mrn)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package dp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+//MARK: Noise
+
+// Laplace samples noise from Laplace(0, scale)
+func Laplace(scale float64) (noise float64, err error) {
+	u, err := uniform()
+	if err != nil {
+		return
+	}
+
+	// inverse cdf on u in (-1/2, 1/2)
+	u -= 0.5
+	if u < 0 {
+		noise = scale * math.Log(1+2*u)
+	} else {
+		noise = -scale * math.Log(1-2*u)
+	}
+
+	return
+}
+
+// Gaussian samples noise from N(0, sigma^2)
+func Gaussian(sigma float64) (noise float64, err error) {
+	u1, err := uniform()
+	if err != nil {
+		return
+	}
+
+	u2, err := uniform()
+	if err != nil {
+		return
+	}
+
+	// Box-Muller
+	noise = sigma * math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+	return
+}
+
+// LaplaceScale calibrates the Laplace mechanism for an L1 sensitivity
+func LaplaceScale(sensitivity float64, epsilon float64) float64 {
+	return sensitivity / epsilon
+}
+
+// GaussianSigma calibrates the Gaussian mechanism for an L2 sensitivity.
+// The classic bound holds for epsilon < 1.
+func GaussianSigma(sensitivity float64, epsilon float64, delta float64) float64 {
+	return sensitivity * math.Sqrt(2*math.Log(1.25/delta)) / epsilon
+}
+
+// uniform samples from the open interval (0, 1) with 53 bits of precision
+func uniform() (u float64, err error) {
+	b := make([]byte, 8)
+	for u == 0 {
+		_, err = rand.Read(b)
+		if err != nil {
+			err = errors.New("Cannot read randomness: " + err.Error())
+			return
+		}
+
+		u = float64(binary.BigEndian.Uint64(b)>>11) / (1 << 53)
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+package dp
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLaplace(t *testing.T) {
+	n := 20000
+	scale := 2.0
+
+	sumAbs := 0.0
+	for i := 0; i < n; i++ {
+		x, err := Laplace(scale)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sumAbs += math.Abs(x)
+	}
+
+	// E|X| = scale
+	if mean := sumAbs / float64(n); math.Abs(mean-scale) > 0.1 {
+		t.Errorf("Mean absolute noise %f. Expected about %f.", mean, scale)
+	}
+}
+
+func TestGaussian(t *testing.T) {
+	n := 20000
+	sigma := 3.0
+
+	sumSq := 0.0
+	for i := 0; i < n; i++ {
+		x, err := Gaussian(sigma)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sumSq += x * x
+	}
+
+	if std := math.Sqrt(sumSq / float64(n)); math.Abs(std-sigma) > 0.15 {
+		t.Errorf("Noise deviation %f. Expected about %f.", std, sigma)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ledger")
+	defer os.RemoveAll(dir)
+	fp := path.Join(dir, "ledger.json")
+
+	ledger, err := ReadLedger(fp, 1.0, 1e-5)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err = ledger.Charge("first", 0.6, 1e-6); err != nil {
+		t.Error(err)
+	}
+	ledger.Write(fp)
+
+	// the budget of an existing ledger can't be raised
+	ledger, _ = ReadLedger(fp, 10.0, 1e-5)
+	if err = ledger.Charge("second", 0.6, 1e-6); err == nil {
+		t.Error("Error: charged over budget")
+	}
+
+	if err = ledger.Charge("third", 0.4, 1e-6); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,72 @@
+package dp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Ledger tracks the privacy budget spent on a corpus. Under basic
+// composition, the epsilons and deltas of all releases add up.
+type Ledger struct {
+	Epsilon float64
+	Delta   float64
+	Entries []LedgerEntry
+}
+
+type LedgerEntry struct {
+	Time    time.Time
+	Query   string
+	Epsilon float64
+	Delta   float64
+}
+
+// ReadLedger reads a ledger file, or starts a new one with the given budget
+// if the file doesn't exist yet. The budget of an existing ledger is kept.
+func ReadLedger(filepath string, epsilon float64, delta float64) (ledger Ledger, err error) {
+	ledgerBytes, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return Ledger{Epsilon: epsilon, Delta: delta}, nil
+	}
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(ledgerBytes, &ledger)
+	return
+}
+
+// Spent is the budget used so far
+func (l Ledger) Spent() (epsilon float64, delta float64) {
+	for _, e := range l.Entries {
+		epsilon += e.Epsilon
+		delta += e.Delta
+	}
+	return
+}
+
+// Charge records a release, refusing it if it would exceed the budget
+func (l *Ledger) Charge(query string, epsilon float64, delta float64) (err error) {
+	spentEpsilon, spentDelta := l.Spent()
+
+	if spentEpsilon+epsilon > l.Epsilon || spentDelta+delta > l.Delta {
+		err = errors.New(fmt.Sprintf("Privacy budget exceeded: spent (%g, %g) of (%g, %g), asked for (%g, %g)", spentEpsilon, spentDelta, l.Epsilon, l.Delta, epsilon, delta))
+		return
+	}
+
+	l.Entries = append(l.Entries, LedgerEntry{time.Now(), query, epsilon, delta})
+	return
+}
+
+func (l Ledger) Write(filepath string) (err error) {
+	ledgerBytes, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(filepath, ledgerBytes, 0660)
+	return
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/pfs"
+	"github.com/agrinman/alvis/pks"
+)
+
+//MARK: Dummy tokens
+
+// dummyMask spreads dummies at random positions among n real tokens, so the
+// note has a multiple of bucket tokens. true marks a dummy.
+func dummyMask(n int, bucket int) (mask []bool, err error) {
+	total := ((n + bucket - 1) / bucket) * bucket
+	if total == 0 {
+		total = bucket
+	}
+
+	mask = make([]bool, total)
+	for i := n; i < total; i++ {
+		mask[i] = true
+	}
+
+	// Fisher-Yates shuffle
+	for i := total - 1; i > 0; i-- {
+		j, randErr := randIntn(i + 1)
+		if randErr != nil {
+			err = randErr
+			return
+		}
+		mask[i], mask[j] = mask[j], mask[i]
+	}
+
+	return
+}
+
+// spliceDummies interleaves real entries with dummies where mask is set
+func spliceDummies(real []string, mask []bool, dummy func() (string, error)) (result []string, err error) {
+	result = make([]string, len(mask))
+
+	next := 0
+	for i, isDummy := range mask {
+		if !isDummy {
+			result[i] = real[next]
+			next += 1
+			continue
+		}
+
+		result[i], err = dummy()
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// maskPositions are the positions of n real tokens among the dummies of
+// mask, or 0 to n-1 without a mask.
+func maskPositions(mask []bool, n int) (positions []int) {
+	positions = make([]int, 0, n)
+	for i := range mask {
+		if !mask[i] {
+			positions = append(positions, i)
+		}
+	}
+	if mask == nil {
+		for i := 0; i < n; i++ {
+			positions = append(positions, i)
+		}
+	}
+
+	return
+}
+
+func dummyKeywordToken() (string, error) {
+	ctxt, err := pks.Dummy()
+	if err != nil {
+		return "", err
+	}
+
+	return base36.EncodeFixed(ctxt)
+}
+
+func dummyFrequencyToken(master MasterKey) func() (string, error) {
+	return func() (string, error) {
+		hidden, err := pfs.Dummy(master.FrequencyKey.OuterKey)
+		if err != nil {
+			return "", err
+		}
+
+		return base36.EncodeFixed(hidden)
+	}
+}
+
+// dummyDetachedToken is as long as the detached ciphertext of a word of the
+// note picked at random, so dummies follow the lengths of real entries, but
+// it does not uncover.
+func dummyDetachedToken(tokens []string, bucket int) func() (string, error) {
+	return func() (string, error) {
+		length := 0
+		if len(tokens) > 0 {
+			i, err := randIntn(len(tokens))
+			if err != nil {
+				return "", err
+			}
+			length = len(tokens[i])
+		}
+
+		key, err := cryptutil.RandKey()
+		if err != nil {
+			return "", err
+		}
+
+		ctxt, err := cryptutil.AESEncrypt(key, cryptutil.PadToBucket(make([]byte, length), bucket))
+		if err != nil {
+			return "", err
+		}
+
+		return base36.EncodeFixed(ctxt)
+	}
+}
+
+// isDummyToken recognizes an encoded frequency token as a dummy
+func isDummyToken(freqOuter []byte, token string) bool {
+	tbytes, err := base36.DecodeString(token)
+	if err != nil {
+		return false
+	}
+
+	recognized, err := pfs.Recognize(freqOuter, tbytes)
+	if err != nil {
+		return false
+	}
+
+	return pfs.IsDummy(freqOuter, recognized)
+}
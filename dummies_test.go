@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+func TestSpliceDummies(t *testing.T) {
+	mask, err := dummyMask(5, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dummies := 0
+	for _, isDummy := range mask {
+		if isDummy {
+			dummies += 1
+		}
+	}
+	if len(mask) != 8 || dummies != 3 {
+		t.Fatalf("Mask of 5 tokens in buckets of 4: %v", mask)
+	}
+
+	real := []string{"a", "b", "c", "d", "e"}
+	spliced, err := spliceDummies(real, mask, func() (string, error) { return "x", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the real entries keep their order at the unmasked positions
+	positions := maskPositions(mask, len(real))
+	for i, p := range positions {
+		if spliced[p] != real[i] {
+			t.Fatalf("Entry %d at %d is %s. Expected %s.", i, p, spliced[p], real[i])
+		}
+	}
+	if strings.Count(strings.Join(spliced, ""), "x") != 3 {
+		t.Fatalf("Unexpected dummies: %v", spliced)
+	}
+
+	if positions := maskPositions(nil, 3); len(positions) != 3 || positions[2] != 2 {
+		t.Fatalf("Positions without a mask: %v", positions)
+	}
+}
+
+func TestDummiesStrippedOnDecrypt(t *testing.T) {
+	master := testMasterKey(t)
+	freqOuter := master.FrequencyKey.OuterKey
+
+	payload, err := encryptFreeText(master, EncryptOptions{TokenBucket: 8}, noteContext{}, "Patient has STEMI")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tokens are padded to the bucket, the dummies recognized by the frequency key
+	tokens := payloadTokens(payload, "frequency_enc")
+	if len(tokens) != 8 || len(payloadTokens(payload, "keyword_enc")) != 8 {
+		t.Fatalf("Expected 8 tokens, got %d", len(tokens))
+	}
+
+	dummies := 0
+	for _, token := range tokens {
+		if isDummyToken(freqOuter, token) {
+			dummies += 1
+		}
+	}
+	if dummies != 5 || noteWordCount(payload, freqOuter) != 3 {
+		t.Fatalf("Expected 5 dummies among %d tokens, got %d", len(tokens), dummies)
+	}
+
+	text, hits := decryptFreeText(payload, []pks.PrivateKey{master.KeywordKey.Extract("stemi")}, freqOuter, DecryptOptions{})
+	expected := tagOf(master, "patient") + " " + tagOf(master, "has") + " stemi"
+	if text != expected || len(hits) != 1 || hits[0] != "stemi" {
+		t.Fatalf("Decrypted %q with hits %v, expected %q", text, hits, expected)
+	}
+}
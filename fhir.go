@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/agrinman/alvis/pks"
+
+	"github.com/fatih/color"
+)
+
+// fhirPayloadExtension marks attachments whose data holds an encrypted
+// payload. Their contentType stays that of the original text.
+const fhirPayloadExtension = "urn:alvis:encrypted-payload"
+
+//MARK: FHIR Encryption/Decryption
+func EncryptAndSaveFHIRFile(inpath string, outpath string, master MasterKey, opts EncryptOptions) (err error) {
+	bundle, err := readPatientFile(inpath)
+	if err != nil {
+		return
+	}
+
+	note := noteContext{File: inpath, Patient: documentPatientID(bundle, opts.Schema.PatientField, inpath)}
+
+	err = ApplyCryptorToFields(bundle, opts.Schema.DateFields, newDateShifter(master, opts, note.Patient).Field)
+	if err != nil {
+		return
+	}
+
+	if opts.DateORE {
+		err = walkFHIRResources(bundle, func(resource map[string]interface{}) error {
+			_, hasValue := fhirValueString(resource)
+			if len(fhirAttachments(resource)) == 0 && !hasValue {
+				return nil
+			}
+			return ApplyCryptorToFields(resource, opts.Schema.noteDateFields("fhir"), oreDates(master))
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	fieldKey := master.FieldKey()
+	err = ApplyCryptorToFields(bundle, opts.Schema.DetFields, func(value string) (string, error) {
+		return encryptField(fieldKey, value)
+	})
+	if err != nil {
+		return
+	}
+
+	err = walkFHIRResources(bundle, func(resource map[string]interface{}) error {
+		resourceNote := note
+		resourceNote.Record, _ = resource["resourceType"].(string)
+
+		for _, attachment := range fhirAttachments(resource) {
+			text, ok, decodeErr := attachmentText(attachment)
+			if decodeErr != nil {
+				return decodeErr
+			}
+			if !ok {
+				continue
+			}
+
+			payload, encErr := encryptFreeText(master, opts, resourceNote, text)
+			if encErr != nil {
+				return encErr
+			}
+
+			payloadBytes, encErr := json.Marshal(payload)
+			if encErr != nil {
+				return encErr
+			}
+
+			setAttachmentData(attachment, payloadBytes)
+			markPayload(attachment, true)
+		}
+
+		if text, ok := fhirValueString(resource); ok {
+			payload, encErr := encryptFreeText(master, opts, resourceNote, text)
+			if encErr != nil {
+				return encErr
+			}
+
+			resource["valueString"] = encodeCompactPayload(payload)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = writePatient(bundle, outpath)
+	return
+}
+
+func DecryptAndSaveFHIRFile(inpath string, outpath string, keywordKeys []pks.PrivateKey, freqOuter []byte, opts DecryptOptions) (err error) {
+	bundle, err := readPatientFile(inpath)
+	if err != nil {
+		return
+	}
+
+	stats := make(map[string]int)
+	statsMutex := &sync.Mutex{}
+	countHits := func(hits []string) {
+		statsMutex.Lock()
+		for _, w := range hits {
+			stats[w] += 1
+		}
+		statsMutex.Unlock()
+	}
+
+	err = walkFHIRResources(bundle, func(resource map[string]interface{}) error {
+		for _, attachment := range fhirAttachments(resource) {
+			if !isPayloadAttachment(attachment) {
+				continue
+			}
+
+			payload, decodeErr := attachmentPayload(attachment)
+			if decodeErr != nil {
+				return decodeErr
+			}
+
+			text, hits := decryptFreeText(payload, keywordKeys, freqOuter, opts)
+			countHits(hits)
+
+			setAttachmentData(attachment, []byte(text))
+			markPayload(attachment, false)
+		}
+
+		if cell, ok := fhirValueString(resource); ok {
+			payload, decodeErr := decodeCompactPayload(cell)
+			if decodeErr != nil {
+				return decodeErr
+			}
+
+			text, hits := decryptFreeText(payload, keywordKeys, freqOuter, opts)
+			countHits(hits)
+
+			resource["valueString"] = text
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	color.Green("-- stats on %s --", inpath)
+	printStats(stats)
+
+	err = writePatient(bundle, outpath)
+	return
+}
+
+//MARK: FHIR helpers
+
+// walkFHIRResources calls fn on a resource, every entry of a Bundle and every
+// contained resource, recursively.
+func walkFHIRResources(resource map[string]interface{}, fn func(map[string]interface{}) error) (err error) {
+	err = fn(resource)
+	if err != nil {
+		return
+	}
+
+	var children []map[string]interface{}
+
+	entries, _ := resource["entry"].([]interface{})
+	for _, e := range entries {
+		entry, _ := e.(map[string]interface{})
+		if child, ok := entry["resource"].(map[string]interface{}); ok {
+			children = append(children, child)
+		}
+	}
+
+	contained, _ := resource["contained"].([]interface{})
+	for _, c := range contained {
+		if child, ok := c.(map[string]interface{}); ok {
+			children = append(children, child)
+		}
+	}
+
+	for _, child := range children {
+		err = walkFHIRResources(child, fn)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// fhirAttachments returns the narrative attachments of DocumentReference
+// (content.attachment) and DiagnosticReport (presentedForm) resources.
+func fhirAttachments(resource map[string]interface{}) (attachments []map[string]interface{}) {
+	switch resource["resourceType"] {
+	case "DocumentReference":
+		contents, _ := resource["content"].([]interface{})
+		for _, c := range contents {
+			content, _ := c.(map[string]interface{})
+			if attachment, ok := content["attachment"].(map[string]interface{}); ok {
+				attachments = append(attachments, attachment)
+			}
+		}
+	case "DiagnosticReport":
+		forms, _ := resource["presentedForm"].([]interface{})
+		for _, f := range forms {
+			if attachment, ok := f.(map[string]interface{}); ok {
+				attachments = append(attachments, attachment)
+			}
+		}
+	}
+
+	return
+}
+
+func fhirValueString(resource map[string]interface{}) (text string, ok bool) {
+	if resource["resourceType"] != "Observation" {
+		return
+	}
+
+	text, ok = resource["valueString"].(string)
+	return
+}
+
+// attachmentText decodes the inline base64 data of a text/* attachment.
+// Attachments that only reference a url, and binary ones like PDFs, DICOM
+// or images, have no text to hide and pass through unchanged.
+func attachmentText(attachment map[string]interface{}) (text string, ok bool, err error) {
+	contentType, _ := attachment["contentType"].(string)
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "text/") {
+		return
+	}
+
+	data, ok := attachment["data"].(string)
+	if !ok {
+		return
+	}
+
+	textBytes, err := base64.StdEncoding.DecodeString(data)
+	text = string(textBytes)
+	return
+}
+
+// setAttachmentData replaces the inline data of an attachment, dropping the
+// size and hash of the old data so the resource stays valid.
+func setAttachmentData(attachment map[string]interface{}, data []byte) {
+	attachment["data"] = base64.StdEncoding.EncodeToString(data)
+
+	delete(attachment, "size")
+	delete(attachment, "hash")
+}
+
+// markPayload adds or removes the extension marking an attachment as an
+// encrypted payload, keeping any other extensions.
+func markPayload(attachment map[string]interface{}, encrypted bool) {
+	extensions, _ := attachment["extension"].([]interface{})
+
+	var kept []interface{}
+	for _, e := range extensions {
+		if ext, ok := e.(map[string]interface{}); !ok || ext["url"] != fhirPayloadExtension {
+			kept = append(kept, e)
+		}
+	}
+
+	if encrypted {
+		kept = append(kept, map[string]interface{}{"url": fhirPayloadExtension, "valueBoolean": true})
+	}
+
+	if len(kept) == 0 {
+		delete(attachment, "extension")
+		return
+	}
+	attachment["extension"] = kept
+}
+
+func isPayloadAttachment(attachment map[string]interface{}) bool {
+	extensions, _ := attachment["extension"].([]interface{})
+	for _, e := range extensions {
+		if ext, ok := e.(map[string]interface{}); ok && ext["url"] == fhirPayloadExtension {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentPayload(attachment map[string]interface{}) (payload map[string]interface{}, err error) {
+	data, _ := attachment["data"].(string)
+
+	payloadBytes, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(payloadBytes, &payload)
+	return
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+const testFHIRBundle = `{"resourceType": "Bundle", "entry": [
+	{"resource": {"resourceType": "Patient", "identifier": [{"value": "MRN1"}]}},
+	{"resource": {"resourceType": "DocumentReference", "content": [
+		{"attachment": {"contentType": "text/plain; charset=utf-8", "data": "UGF0aWVudCBoYXMgU1RFTUk=", "size": 17}},
+		{"attachment": {"contentType": "application/pdf", "data": "JVBERi0xLjQKc3RlbWk="}}
+	]}},
+	{"resource": {"resourceType": "Observation", "valueString": "stemi ruled out"}}
+]}`
+
+func TestFHIRRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-fhir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	inpath := path.Join(dir, "bundle.json")
+	ioutil.WriteFile(inpath, []byte(testFHIRBundle), 0660)
+
+	err = EncryptAndSaveFHIRFile(inpath, inpath+".enc", master, EncryptOptions{Schema: defaultSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := readPatientFile(inpath + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attachments []map[string]interface{}
+	walkFHIRResources(encrypted, func(resource map[string]interface{}) error {
+		attachments = append(attachments, fhirAttachments(resource)...)
+		return nil
+	})
+
+	if len(attachments) != 2 {
+		t.Fatalf("Expected 2 attachments, got %d", len(attachments))
+	}
+
+	text, pdf := attachments[0], attachments[1]
+	if !isPayloadAttachment(text) || text["contentType"] != "text/plain; charset=utf-8" || text["size"] != nil {
+		t.Fatalf("Text attachment not encrypted in place: %v", text)
+	}
+	if isPayloadAttachment(pdf) || pdf["contentType"] != "application/pdf" || pdf["data"] != "JVBERi0xLjQKc3RlbWk=" {
+		t.Fatalf("PDF attachment changed: %v", pdf)
+	}
+
+	err = DecryptAndSaveFHIRFile(inpath+".enc", inpath+".dec", []pks.PrivateKey{master.KeywordKey.Extract("stemi")}, master.FrequencyKey.OuterKey, DecryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := readPatientFile(inpath + ".dec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments = nil
+	var observation string
+	walkFHIRResources(decrypted, func(resource map[string]interface{}) error {
+		attachments = append(attachments, fhirAttachments(resource)...)
+		if value, ok := fhirValueString(resource); ok {
+			observation = value
+		}
+		return nil
+	})
+
+	if isPayloadAttachment(attachments[0]) || attachments[0]["extension"] != nil {
+		t.Fatalf("Decrypted attachment is still marked: %v", attachments[0])
+	}
+
+	data, _ := base64.StdEncoding.DecodeString(attachments[0]["data"].(string))
+	if !strings.Contains(string(data), "stemi") || !strings.Contains(observation, "stemi") {
+		t.Fatalf("Keyword not found in decrypted text: %s, %s", data, observation)
+	}
+
+	if attachments[1]["data"] != "JVBERi0xLjQKc3RlbWk=" {
+		t.Fatalf("PDF attachment changed: %v", attachments[1])
+	}
+}
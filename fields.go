@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/dfe"
+)
+
+//MARK: Structured field encryption
+
+// encryptField deterministically encrypts a structured value, so equal
+// values still join across files.
+func encryptField(fieldKey []byte, value string) (result string, err error) {
+	if value == "" {
+		return
+	}
+
+	ctxt, err := dfe.Encrypt(fieldKey, []byte(value))
+	if err != nil {
+		return
+	}
+
+	result, err = base36.Encode(ctxt)
+	return
+}
+
+func decryptField(fieldKey []byte, value string) (result string, err error) {
+	if value == "" {
+		return
+	}
+
+	ctxt, err := base36.DecodeString(value)
+	if err != nil {
+		return
+	}
+
+	ptxt, err := dfe.Decrypt(fieldKey, ctxt)
+	result = string(ptxt)
+	return
+}
+
+//MARK: Field paths
+
+// ApplyCryptorToFields runs the cryptor over every value at the given dotted
+// paths of a JSON document, e.g. "mrn", "Car.icd_codes" or
+// "entry.resource.identifier.value". Arrays along the path are walked
+// transparently. Numbers are passed to the cryptor as strings.
+func ApplyCryptorToFields(doc map[string]interface{}, paths []string, cryptor func(string) (string, error)) (err error) {
+	for _, p := range paths {
+		err = applyCryptorToPath(doc, strings.Split(p, "."), cryptor)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func applyCryptorToPath(node interface{}, path []string, cryptor func(string) (string, error)) (err error) {
+	switch n := node.(type) {
+	case []interface{}:
+		for i := range n {
+			if len(path) == 0 {
+				n[i], err = applyCryptorToValue(n[i], cryptor)
+			} else {
+				err = applyCryptorToPath(n[i], path, cryptor)
+			}
+			if err != nil {
+				return
+			}
+		}
+	case []map[string]interface{}:
+		for i := range n {
+			err = applyCryptorToPath(n[i], path, cryptor)
+			if err != nil {
+				return
+			}
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return
+		}
+
+		child, ok := n[path[0]]
+		if !ok {
+			return
+		}
+
+		if len(path) == 1 {
+			if _, isList := child.([]interface{}); !isList {
+				n[path[0]], err = applyCryptorToValue(child, cryptor)
+				return
+			}
+		}
+
+		err = applyCryptorToPath(child, path[1:], cryptor)
+	}
+
+	return
+}
+
+// lookupField returns the first value found at a dotted path
+func lookupField(node interface{}, path []string) (value string, ok bool) {
+	switch n := node.(type) {
+	case []interface{}:
+		for i := range n {
+			if value, ok = lookupField(n[i], path); ok {
+				return
+			}
+		}
+	case []map[string]interface{}:
+		for i := range n {
+			if value, ok = lookupField(n[i], path); ok {
+				return
+			}
+		}
+	case map[string]interface{}:
+		if len(path) > 0 {
+			return lookupField(n[path[0]], path[1:])
+		}
+	case string:
+		return n, len(path) == 0
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), len(path) == 0
+	}
+
+	return
+}
+
+func applyCryptorToValue(value interface{}, cryptor func(string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return cryptor(v)
+	case float64:
+		return cryptor(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	return value, nil
+}
+
+//MARK: HL7 field references
+
+// hl7FieldRef is a schema entry like "PID-3", naming a field of a segment
+type hl7FieldRef struct {
+	Segment string
+	Index   int
+}
+
+func parseHL7FieldRefs(refs []string) (fieldRefs []hl7FieldRef, err error) {
+	for _, ref := range refs {
+		parts := strings.SplitN(ref, "-", 2)
+		if len(parts) != 2 || len(parts[0]) != 3 {
+			err = errors.New(fmt.Sprintf("Invalid HL7 field: %s", ref))
+			return
+		}
+
+		index, convErr := strconv.Atoi(parts[1])
+		if convErr != nil || index < 1 {
+			err = errors.New(fmt.Sprintf("Invalid HL7 field: %s", ref))
+			return
+		}
+
+		// MSH-1 is the field separator itself, so MSH fields are shifted by one
+		if parts[0] == "MSH" {
+			index -= 1
+		}
+
+		fieldRefs = append(fieldRefs, hl7FieldRef{parts[0], index})
+	}
+
+	return
+}
+
+// ApplyCryptorToHL7Fields runs the cryptor over every component of the
+// referenced fields, keeping the component and repetition structure.
+func ApplyCryptorToHL7Fields(segments []string, refs []hl7FieldRef, cryptor func(string) (string, error)) (err error) {
+	if len(refs) == 0 {
+		return
+	}
+
+	delims := defaultHL7Delimiters
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "MSH") && len(segment) >= 8 {
+			delims = hl7Delimiters{segment[3:4], segment[4:5], segment[5:6], segment[6:7], segment[7:8]}
+		}
+
+		fields := strings.Split(segment, delims.Field)
+		for _, ref := range refs {
+			if fields[0] != ref.Segment || ref.Index >= len(fields) || ref.Index < 2 && ref.Segment == "MSH" {
+				continue
+			}
+
+			repetitions := strings.Split(fields[ref.Index], delims.Repetition)
+			for r := range repetitions {
+				components := strings.Split(repetitions[r], delims.Component)
+				for c := range components {
+					components[c], err = cryptor(components[c])
+					if err != nil {
+						return
+					}
+				}
+				repetitions[r] = strings.Join(components, delims.Component)
+			}
+			fields[ref.Index] = strings.Join(repetitions, delims.Repetition)
+		}
+
+		segments[i] = strings.Join(fields, delims.Field)
+	}
+
+	return
+}
+
+//MARK: Uncovering fields
+
+// UncoverAndSaveFieldsFile reverses the deterministic encryption of the
+// schema's structured fields, in encrypted or partially-decrypted files.
+// With unshiftDates, shifted date fields are moved back as well.
+func UncoverAndSaveFieldsFile(inpath string, outpath string, master MasterKey, format string, schema Schema, unshiftDates bool) (err error) {
+	fieldKey := master.FieldKey()
+	uncover := func(value string) (string, error) {
+		return decryptField(fieldKey, value)
+	}
+
+	opts := EncryptOptions{Schema: schema}
+	if unshiftDates {
+		opts.DateMode = "shift"
+	}
+
+	switch format {
+	case "csv":
+		header, rows, readErr := readTableFile(inpath, schema)
+		if readErr != nil {
+			return readErr
+		}
+
+		detCols, colErr := columnIndexes(header, schema.DetColumns)
+		if colErr != nil {
+			return colErr
+		}
+
+		dateCols, colErr := columnIndexes(header, schema.DateColumns)
+		if colErr != nil {
+			return colErr
+		}
+
+		patientCol, colErr := patientColumnIndex(header, schema)
+		if colErr != nil {
+			return colErr
+		}
+
+		err = ApplyCryptorToTable(rows, func(row []string) (rowErr error) {
+			for _, i := range detCols {
+				row[i], rowErr = uncover(row[i])
+				if rowErr != nil {
+					return
+				}
+			}
+
+			patient := defaultPatientID(inpath)
+			if patientCol >= 0 {
+				patient = row[patientCol]
+			}
+
+			dates := newDateShifter(master, opts, patient).inverse()
+			for _, i := range dateCols {
+				row[i], _ = dates.Field(row[i])
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+
+		err = writeTableFile(outpath, header, rows, schema)
+
+	case "hl7":
+		fieldRefs, refErr := parseHL7FieldRefs(schema.DetFields)
+		if refErr != nil {
+			return refErr
+		}
+
+		dateRefs, refErr := parseHL7FieldRefs(schema.DateFields)
+		if refErr != nil {
+			return refErr
+		}
+
+		segments, terminator, readErr := readHL7File(inpath)
+		if readErr != nil {
+			return readErr
+		}
+
+		err = ApplyCryptorToHL7Fields(segments, fieldRefs, uncover)
+		if err != nil {
+			return
+		}
+
+		for _, message := range hl7Messages(segments) {
+			patient := hl7MessagePatient(message, schema.hl7PatientField(), inpath)
+
+			err = ApplyCryptorToHL7Fields(message, dateRefs, newDateShifter(master, opts, patient).inverse().Field)
+			if err != nil {
+				return
+			}
+		}
+
+		err = writeHL7File(outpath, segments, terminator)
+
+	default:
+		doc, readErr := readPatientFile(inpath)
+		if readErr != nil {
+			return readErr
+		}
+
+		err = ApplyCryptorToFields(doc, schema.DetFields, uncover)
+		if err != nil {
+			return
+		}
+
+		patient := documentPatientID(doc, schema.PatientField, inpath)
+
+		err = ApplyCryptorToFields(doc, schema.DateFields, newDateShifter(master, opts, patient).inverse().Field)
+		if err != nil {
+			return
+		}
+
+		err = writePatient(doc, outpath)
+	}
+
+	return
+}
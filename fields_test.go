@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+const testPatientFile = `{"mrn": "123", "Car": [{"icd": ["I21", "I22"], "date": "2012-03-04", "free_text": "Patient has STEMI"}]}`
+
+func TestPatientFieldsRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	schema := defaultSchema
+	schema.DetFields = []string{"mrn", "Car.icd"}
+	schema.DateFields = []string{"Car.date"}
+	schema.PatientField = "mrn"
+
+	inpath := path.Join(dir, "patient.json")
+	ioutil.WriteFile(inpath, []byte(testPatientFile), 0660)
+
+	err = EncryptAndSavePatientFile(inpath, inpath+".enc", master, EncryptOptions{Schema: schema, DateMode: "shift"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := readPatientFile(inpath + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := encrypted["Car"].([]interface{})[0].(map[string]interface{})
+	shifted, _ := newDateShifter(master, EncryptOptions{DateMode: "shift"}, "123").Field("2012-03-04")
+	if encrypted["mrn"] == "123" || record["date"] != shifted || record["date"] == "2012-03-04" {
+		t.Fatalf("Fields not encrypted: %v", encrypted)
+	}
+
+	err = DecryptAndSavePatientFile(inpath+".enc", inpath+".dec", []pks.PrivateKey{master.KeywordKey.Extract("stemi")}, master.FrequencyKey.OuterKey, DecryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UncoverAndSaveFieldsFile(inpath+".dec", inpath+".unc", master, "json", schema, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uncovered, err := readPatientFile(inpath + ".unc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record = uncovered["Car"].([]interface{})[0].(map[string]interface{})
+	codes := record["icd"].([]interface{})
+	if uncovered["mrn"] != "123" || len(codes) != 2 || codes[0] != "I21" || codes[1] != "I22" || record["date"] != "2012-03-04" {
+		t.Fatalf("Fields not uncovered: %v", uncovered)
+	}
+
+	expected := tagOf(master, "patient") + " " + tagOf(master, "has") + " stemi"
+	if record["free_text"] != expected {
+		t.Fatalf("Decrypted %q, expected %q", record["free_text"], expected)
+	}
+}
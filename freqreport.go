@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/agrinman/alvis/dp"
+)
+
+// freqReportOptions calibrate a differentially private frequency report.
+// Each patient contributes at most MaxTags distinct tags, counted once each
+// however many notes and files hold them, which bounds the sensitivity of
+// the report.
+type freqReportOptions struct {
+	Mechanism string
+	Epsilon   float64
+	Delta     float64
+	MaxTags   int
+}
+
+type noisyCount struct {
+	Tag   string
+	Count float64
+}
+
+// patientTags are the distinct tags of every patient ID, across the files
+// holding the patient's notes.
+type patientTags map[string]map[string]bool
+
+func (p patientTags) addFile(file corpusFile) {
+	for n, note := range file.Notes {
+		tags := p[file.Patients[n]]
+		if tags == nil {
+			tags = make(map[string]bool)
+			p[file.Patients[n]] = tags
+		}
+
+		for _, t := range note.Tags {
+			if t != "" {
+				tags[t] = true
+			}
+		}
+	}
+}
+
+// counts is the number of patients of each tag, with the tags of every
+// patient down-sampled at random to at most maxTags.
+func (p patientTags) counts(maxTags int) (counts map[string]int, err error) {
+	counts = make(map[string]int)
+	for _, tagSet := range p {
+		tags, sampleErr := sampleTags(tagSet, maxTags)
+		if sampleErr != nil {
+			err = sampleErr
+			return
+		}
+
+		for _, t := range tags {
+			counts[t] += 1
+		}
+	}
+
+	return
+}
+
+// sampleTags picks at most maxTags of a set of tags at random
+func sampleTags(tagSet map[string]bool, maxTags int) (tags []string, err error) {
+	for t := range tagSet {
+		tags = append(tags, t)
+	}
+
+	// partial Fisher-Yates shuffle
+	for i := 0; i < maxTags && i < len(tags); i++ {
+		j, randErr := randIntn(len(tags) - i)
+		if randErr != nil {
+			err = randErr
+			return
+		}
+		tags[i], tags[i+j] = tags[i+j], tags[i]
+	}
+
+	if len(tags) > maxTags {
+		tags = tags[:maxTags]
+	}
+
+	return
+}
+
+// validate checks the options before any budget is spent. The classic
+// Gaussian calibration only holds for epsilon < 1.
+func (opts freqReportOptions) validate() error {
+	if opts.Epsilon <= 0 || opts.Delta <= 0 || opts.MaxTags < 1 {
+		return errors.New("epsilon, delta and max-tags must be positive")
+	}
+
+	switch opts.Mechanism {
+	case "laplace", "":
+	case "gaussian":
+		if opts.Epsilon >= 1 {
+			return errors.New(fmt.Sprintf("The gaussian mechanism needs epsilon < 1, got %g. Use -mechanism laplace.", opts.Epsilon))
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unknown mechanism: %s. Expected one of: laplace, gaussian", opts.Mechanism))
+	}
+
+	return nil
+}
+
+// releaseNoisyCounts adds calibrated noise to every count. Which tags exist
+// is itself private, so a tag is only released when its noisy count clears
+// a threshold that a single patient's tags exceed with probability < delta.
+func releaseNoisyCounts(counts map[string]int, opts freqReportOptions) (released []noisyCount, err error) {
+	err = opts.validate()
+	if err != nil {
+		return
+	}
+
+	sensitivity := float64(opts.MaxTags)
+
+	var noise func() (float64, error)
+	var threshold float64
+
+	switch opts.Mechanism {
+	case "laplace", "":
+		scale := dp.LaplaceScale(sensitivity, opts.Epsilon)
+		noise = func() (float64, error) { return dp.Laplace(scale) }
+		threshold = 1 + scale*math.Log(sensitivity/(2*opts.Delta))
+	case "gaussian":
+		// half the delta for the noise, half for the threshold
+		sigma := dp.GaussianSigma(math.Sqrt(sensitivity), opts.Epsilon, opts.Delta/2)
+		noise = func() (float64, error) { return dp.Gaussian(sigma) }
+		threshold = 1 + sigma*math.Sqrt(2*math.Log(2*sensitivity/opts.Delta))
+	}
+
+	for tag, c := range counts {
+		n, noiseErr := noise()
+		if noiseErr != nil {
+			err = noiseErr
+			return
+		}
+
+		if noisy := float64(c) + n; noisy >= threshold {
+			released = append(released, noisyCount{tag, math.Round(noisy)})
+		}
+	}
+
+	sort.Slice(released, func(i, j int) bool { return released[i].Count > released[j].Count })
+	return
+}
+
+func writeNoisyCounts(w io.Writer, released []noisyCount) {
+	fmt.Fprintln(w, "tag,count")
+	for _, nc := range released {
+		fmt.Fprintf(w, "%s,%.0f\n", nc.Tag, nc.Count)
+	}
+}
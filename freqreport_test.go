@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestPatientTags(t *testing.T) {
+	patients := make(patientTags)
+
+	// p1's notes span two files, and count once
+	patients.addFile(corpusFile{Patients: []string{"p1", "p2"}, Notes: []decryptedNote{
+		{Tags: []string{"a", "b", "a", ""}},
+		{Tags: []string{"a"}},
+	}})
+	patients.addFile(corpusFile{Patients: []string{"p1"}, Notes: []decryptedNote{
+		{Tags: []string{"c", "b"}},
+	}})
+
+	counts, err := patients.counts(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 3 || counts["a"] != 2 || counts["b"] != 1 || counts["c"] != 1 {
+		t.Fatalf("Unexpected counts: %v", counts)
+	}
+
+	// down-sampled to the cap, without repeats
+	counts, err = patients.counts(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total != 3 || counts["a"] > 2 || counts["b"] > 1 || counts["c"] > 1 {
+		t.Fatalf("Expected 2 tags of p1 and 1 of p2, got %v", counts)
+	}
+}
+
+func TestReleaseNoisyCounts(t *testing.T) {
+	counts := map[string]int{"common": 100000, "rare": 1}
+
+	for _, mechanism := range []string{"laplace", "gaussian"} {
+		released, err := releaseNoisyCounts(counts, freqReportOptions{mechanism, 0.5, 1e-6, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a tag of a single patient never clears the threshold at this delta
+		if len(released) != 1 || released[0].Tag != "common" {
+			t.Fatalf("%s released %v", mechanism, released)
+		}
+	}
+
+	for _, opts := range []freqReportOptions{{"laplace", 0, 1e-6, 1}, {"laplace", 1, 1e-6, 0}, {"exponential", 1, 1e-6, 1}} {
+		if _, err := releaseNoisyCounts(counts, opts); err == nil {
+			t.Errorf("Released counts with %v", opts)
+		}
+	}
+}
+
+func TestGaussianNeedsSmallEpsilon(t *testing.T) {
+	counts := map[string]int{"common": 100000}
+
+	// the classic calibration under-noises from epsilon 1 on
+	for _, epsilon := range []float64{1, 2} {
+		if _, err := releaseNoisyCounts(counts, freqReportOptions{"gaussian", epsilon, 1e-6, 1}); err == nil {
+			t.Errorf("Released gaussian counts with epsilon %g", epsilon)
+		}
+	}
+
+	if err := (freqReportOptions{"laplace", 2, 1e-6, 1}).validate(); err != nil {
+		t.Errorf("Rejected laplace with epsilon 2: %s", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/pks"
+)
+
+// fuzzyDomain separates fuzzy keys and ciphertexts from exact keywords
+const fuzzyDomain = "alvis-fuzzy"
+
+// fuzzyMaxDistance bounds the edit distance of fuzzy search, since
+// neighborhoods grow with the square of the token length at distance 2.
+const fuzzyMaxDistance = 2
+
+// fuzzyMaxLength truncates tokens before fuzzing, so that fuzzy entries all
+// have the same number of ciphertexts.
+const fuzzyMaxLength = 20
+
+// fuzzyKeywordPattern matches the keywords of fuzzy keys, like pneumonia~
+// or pneumonia~2
+var fuzzyKeywordPattern = regexp.MustCompile(`^(.+)~(\d?)$`)
+
+// parseFuzzyKeyword splits a fuzzy keyword into its word and edit distance,
+// 1 if omitted.
+func parseFuzzyKeyword(keyword string) (word string, distance int, ok bool) {
+	match := fuzzyKeywordPattern.FindStringSubmatch(keyword)
+	if match == nil {
+		return
+	}
+
+	distance = 1
+	if match[2] != "" {
+		distance, _ = strconv.Atoi(match[2])
+	}
+
+	return match[1], distance, true
+}
+
+func isFuzzyKey(sk pks.PrivateKey) bool {
+	_, _, ok := parseFuzzyKeyword(sk.Keyword)
+	return ok
+}
+
+// deletionNeighborhood is the word and every distinct string obtained by
+// deleting up to distance runes of it. Words within the edit distance share
+// a string of their neighborhoods, as do some words up to twice as far.
+func deletionNeighborhood(word string, distance int) (neighborhood []string) {
+	runes := []rune(word)
+	if len(runes) > fuzzyMaxLength {
+		runes = runes[:fuzzyMaxLength]
+	}
+
+	seen := map[string]bool{string(runes): true}
+	level := []string{string(runes)}
+	neighborhood = append(neighborhood, level...)
+
+	for d := 0; d < distance; d++ {
+		var next []string
+		for _, s := range level {
+			r := []rune(s)
+			for i := range r {
+				variant := string(r[:i]) + string(r[i+1:])
+				if variant == "" || seen[variant] {
+					continue
+				}
+
+				seen[variant] = true
+				next = append(next, variant)
+			}
+		}
+
+		neighborhood = append(neighborhood, next...)
+		level = next
+	}
+
+	return
+}
+
+// fuzzyEntrySize is the largest neighborhood of a token, to which every
+// fuzzy entry is padded.
+func fuzzyEntrySize(distance int) int {
+	size, choose := 1, 1
+	for k := 1; k <= distance; k++ {
+		choose = choose * (fuzzyMaxLength - k + 1) / k
+		size += choose
+	}
+	return size
+}
+
+// extractFuzzyKeys issues a key for every string of the neighborhood of a
+// fuzzy keyword's word, all labelled by the fuzzy keyword.
+func extractFuzzyKeys(master MasterKey, keyword string) (keys []pks.PrivateKey, err error) {
+	word, distance, ok := parseFuzzyKeyword(keyword)
+	if !ok || distance < 1 || distance > fuzzyMaxDistance {
+		err = errors.New(fmt.Sprintf("Invalid fuzzy keyword: %s. Expected word~ or word~N with N up to %d", keyword, fuzzyMaxDistance))
+		return
+	}
+
+	domain := master.KeywordKey.Domain(fuzzyDomain)
+	for _, variant := range deletionNeighborhood(word, distance) {
+		sk := domain.Extract(variant)
+		sk.Keyword = keyword
+		keys = append(keys, sk)
+	}
+
+	return
+}
+
+//MARK: Fuzzy payloads
+
+// encryptFuzzy hides the deletion neighborhood of every token, one entry
+// per token. Entries are padded with dummies and shuffled, so they reveal
+// neither the token length nor which deletion a ciphertext is.
+func encryptFuzzy(master MasterKey, tokens []string, distance int) (entries []string, err error) {
+	domain := master.KeywordKey.Domain(fuzzyDomain)
+	size := fuzzyEntrySize(distance)
+
+	for _, t := range tokens {
+		var ctxts [][]byte
+		for _, variant := range deletionNeighborhood(t, distance) {
+			ctxt, hideErr := domain.Hide(variant)
+			if hideErr != nil {
+				return nil, hideErr
+			}
+			ctxts = append(ctxts, ctxt)
+		}
+
+		entry, entryErr := joinFuzzyEntry(ctxts, size)
+		if entryErr != nil {
+			return nil, entryErr
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// joinFuzzyEntry pads ciphertexts with dummies up to size, shuffles and
+// encodes them.
+func joinFuzzyEntry(ctxts [][]byte, size int) (entry string, err error) {
+	for len(ctxts) < size {
+		dummy, dummyErr := pks.Dummy()
+		if dummyErr != nil {
+			return "", dummyErr
+		}
+		ctxts = append(ctxts, dummy)
+	}
+
+	// Fisher-Yates shuffle
+	for i := len(ctxts) - 1; i > 0; i-- {
+		j, randErr := randIntn(i + 1)
+		if randErr != nil {
+			return "", randErr
+		}
+		ctxts[i], ctxts[j] = ctxts[j], ctxts[i]
+	}
+
+	var joined []byte
+	for _, ctxt := range ctxts {
+		joined = append(joined, ctxt...)
+	}
+
+	return base36.EncodeFixed(joined)
+}
+
+// dummyFuzzyToken pads the fuzzy entries of dummy tokens
+func dummyFuzzyToken(distance int) func() (string, error) {
+	return func() (string, error) {
+		return joinFuzzyEntry(nil, fuzzyEntrySize(distance))
+	}
+}
+
+// fuzzyLeakage documents what fuzzy entries reveal beyond exact keywords
+func fuzzyLeakage(distance int) []string {
+	return []string{
+		fmt.Sprintf("fuzzy (distance %d): a fuzzy key matches every token sharing a deletion variant with its word, which includes tokens up to %d edits away and short unrelated tokens", distance, 2*distance),
+		fmt.Sprintf("fuzzy (distance %d): key holders learn which tokens are near misspellings, plurals or inflections of their words, not only exact hits", distance),
+		fmt.Sprintf("fuzzy (distance %d): every token carries %d ciphertexts instead of one, which multiplies the size and encryption time of the output, and only its first %d characters are fuzzed", distance, fuzzyEntrySize(distance), fuzzyMaxLength),
+	}
+}
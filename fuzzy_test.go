@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDeletionNeighborhood(t *testing.T) {
+	neighborhood := deletionNeighborhood("stem", 1)
+	if strings.Join(neighborhood, " ") != "stem tem sem stm ste" {
+		t.Fatalf("Unexpected neighborhood: %v", neighborhood)
+	}
+
+	// repeated letters give one variant
+	if neighborhood := deletionNeighborhood("ill", 1); len(neighborhood) != 3 {
+		t.Fatalf("Unexpected neighborhood: %v", neighborhood)
+	}
+
+	if fuzzyEntrySize(1) != 21 || fuzzyEntrySize(2) != 211 {
+		t.Fatalf("Unexpected entry sizes: %d, %d", fuzzyEntrySize(1), fuzzyEntrySize(2))
+	}
+}
+
+func TestFuzzyKeywordRange(t *testing.T) {
+	master := testMasterKey(t)
+
+	for _, keyword := range []string{"stemi", "stemi~0", "stemi~3"} {
+		if _, err := extractFuzzyKeys(master, keyword); err == nil {
+			t.Errorf("Extracted fuzzy keys for %s", keyword)
+		}
+	}
+}
+
+func TestFuzzyRoundTrip(t *testing.T) {
+	master := testMasterKey(t)
+
+	payload, err := encryptFreeText(master, EncryptOptions{FuzzyDistance: 1}, noteContext{}, "no pneumonia. possible stemi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a keyword with two letters swapped finds the word, labelled as asked
+	keys, err := extractFuzzyKeys(master, "pnuemonia~")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, hits := decryptFreeText(payload, keys, nil, DecryptOptions{}); len(hits) != 1 || hits[0] != "pnuemonia~" {
+		t.Fatalf("Unexpected hits: %v", hits)
+	}
+
+	keys, _ = extractFuzzyKeys(master, "pnuemoina~")
+	if _, hits := decryptFreeText(payload, keys, nil, DecryptOptions{}); len(hits) != 0 {
+		t.Fatalf("Unexpected hits for two swaps: %v", hits)
+	}
+}
+
+func TestFuzzyLeakageReported(t *testing.T) {
+	// the leakage is reported even without a report path
+	report := newEncryptReport("", EncryptOptions{FuzzyDistance: 2})
+	if report == nil || len(report.Leakage) != len(fuzzyLeakage(2)) {
+		t.Fatalf("Fuzzy leakage not reported: %v", report)
+	}
+
+	var out bytes.Buffer
+	report.printLeakage(&out)
+	if !strings.Contains(out.String(), "211 ciphertexts") {
+		t.Fatalf("Leakage does not state the cost: %s", out.String())
+	}
+
+	if report := newEncryptReport("", EncryptOptions{}); report != nil {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// tagStat is the corpus-wide count of one frequency tag: its occurrences,
+// the notes and files it occurs in, and its occurrences per record type.
+type tagStat struct {
+	Tag      string         `json:"tag"`
+	Count    int            `json:"count"`
+	DocFreq  int            `json:"doc_freq"`
+	FileFreq int            `json:"file_freq"`
+	Records  map[string]int `json:"records"`
+}
+
+// tagHistogram aggregates the recognized tags of a corpus
+type tagHistogram struct {
+	Files   int        `json:"files"`
+	Notes   int        `json:"notes"`
+	Tokens  int        `json:"tokens"`
+	Records []string   `json:"records"`
+	Tags    []*tagStat `json:"tags"`
+
+	stats map[string]*tagStat
+}
+
+func newTagHistogram() *tagHistogram {
+	return &tagHistogram{stats: make(map[string]*tagStat)}
+}
+
+//MARK: Counting
+
+// addFile counts the tags of every note of a decrypted file
+func (h *tagHistogram) addFile(file corpusFile) {
+	h.Files += 1
+
+	fileTags := make(map[string]bool)
+	for i, note := range file.Notes {
+		h.Notes += 1
+
+		noteTags := make(map[string]bool)
+		for _, t := range note.Tags {
+			stat := h.stat(t)
+			stat.Count += 1
+			stat.Records[file.Records[i]] += 1
+			h.Tokens += 1
+
+			if !noteTags[t] {
+				noteTags[t] = true
+				stat.DocFreq += 1
+			}
+			if !fileTags[t] {
+				fileTags[t] = true
+				stat.FileFreq += 1
+			}
+		}
+	}
+}
+
+func (h *tagHistogram) stat(tag string) *tagStat {
+	stat, ok := h.stats[tag]
+	if !ok {
+		stat = &tagStat{Tag: tag, Records: make(map[string]int)}
+		h.stats[tag] = stat
+	}
+	return stat
+}
+
+// finish sorts the tags by decreasing count, keeping the top k if k > 0
+func (h *tagHistogram) finish(top int) {
+	h.Tags = nil
+	records := make(map[string]bool)
+	for _, stat := range h.stats {
+		h.Tags = append(h.Tags, stat)
+		for r := range stat.Records {
+			records[r] = true
+		}
+	}
+
+	sort.Slice(h.Tags, func(i, j int) bool {
+		if h.Tags[i].Count != h.Tags[j].Count {
+			return h.Tags[i].Count > h.Tags[j].Count
+		}
+		return h.Tags[i].Tag < h.Tags[j].Tag
+	})
+
+	if top > 0 && len(h.Tags) > top {
+		h.Tags = h.Tags[:top]
+	}
+
+	h.Records = nil
+	for r := range records {
+		h.Records = append(h.Records, r)
+	}
+	sort.Strings(h.Records)
+}
+
+//MARK: Output
+
+// writeCSV writes one row per tag, with a count column per record type
+func (h *tagHistogram) writeCSV(w io.Writer) (err error) {
+	writer := csv.NewWriter(w)
+
+	header := append([]string{"tag", "count", "doc_freq", "file_freq"}, h.Records...)
+	err = writer.Write(header)
+	if err != nil {
+		return
+	}
+
+	for _, stat := range h.Tags {
+		row := []string{stat.Tag, strconv.Itoa(stat.Count), strconv.Itoa(stat.DocFreq), strconv.Itoa(stat.FileFreq)}
+		for _, r := range h.Records {
+			row = append(row, strconv.Itoa(stat.Records[r]))
+		}
+
+		err = writer.Write(row)
+		if err != nil {
+			return
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (h *tagHistogram) writeJSON(w io.Writer) (err error) {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func testHistogram() *tagHistogram {
+	h := newTagHistogram()
+	h.addFile(corpusFile{Records: []string{"Car", "Rad"}, Notes: []decryptedNote{
+		{Tags: []string{"a", "a", "b"}},
+		{Tags: []string{"a", "c"}},
+	}})
+	h.addFile(corpusFile{Records: []string{"Rad"}, Notes: []decryptedNote{
+		{Tags: []string{"b", "c"}},
+	}})
+	return h
+}
+
+func TestHistogramCounts(t *testing.T) {
+	h := testHistogram()
+	h.finish(0)
+
+	if h.Files != 2 || h.Notes != 3 || h.Tokens != 7 {
+		t.Fatalf("Unexpected totals: %d files, %d notes, %d tokens", h.Files, h.Notes, h.Tokens)
+	}
+
+	// a occurs 3 times in 2 notes of 1 file
+	a := h.Tags[0]
+	if a.Tag != "a" || a.Count != 3 || a.DocFreq != 2 || a.FileFreq != 1 {
+		t.Fatalf("Unexpected stat: %+v", a)
+	}
+	if a.Records["Car"] != 2 || a.Records["Rad"] != 1 {
+		t.Fatalf("Unexpected records: %v", a.Records)
+	}
+
+	if len(h.Records) != 2 || h.Records[0] != "Car" || h.Records[1] != "Rad" {
+		t.Fatalf("Unexpected record types: %v", h.Records)
+	}
+}
+
+func TestHistogramTop(t *testing.T) {
+	h := testHistogram()
+	h.finish(2)
+
+	// b and c tie at 2, and are ordered by tag
+	if len(h.Tags) != 2 || h.Tags[0].Tag != "a" || h.Tags[1].Tag != "b" {
+		t.Fatalf("Unexpected top tags: %v", h.Tags)
+	}
+
+	h.finish(0)
+	if len(h.Tags) != 3 || h.Tags[2].Tag != "c" || h.Tags[2].FileFreq != 2 {
+		t.Fatalf("Unexpected tags: %v", h.Tags)
+	}
+}
+
+func TestHistogramCSV(t *testing.T) {
+	h := testHistogram()
+	h.finish(0)
+
+	var out bytes.Buffer
+	if err := h.writeCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "tag,count,doc_freq,file_freq,Car,Rad\na,3,2,1,2,1\nb,2,2,2,1,1\nc,2,2,2,0,2\n"
+	if out.String() != expected {
+		t.Fatalf("Wrote %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := testHistogram()
+	h.finish(1)
+
+	var out bytes.Buffer
+	if err := h.writeJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var written tagHistogram
+	if err := json.Unmarshal(out.Bytes(), &written); err != nil {
+		t.Fatal(err)
+	}
+
+	if written.Files != 2 || written.Tokens != 7 || len(written.Tags) != 1 || written.Tags[0].Tag != "a" || written.Tags[0].Records["Car"] != 2 {
+		t.Fatalf("Unexpected histogram: %+v", written)
+	}
+}
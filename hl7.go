@@ -0,0 +1,308 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/agrinman/alvis/pks"
+
+	"github.com/fatih/color"
+)
+
+// hl7TextTypes are the OBX-2 value types whose OBX-5 holds narrative text
+var hl7TextTypes = map[string]bool{"TX": true, "FT": true, "ST": true}
+
+// hl7Delimiters are the encoding characters declared in MSH-1 and MSH-2
+type hl7Delimiters struct {
+	Field        string
+	Component    string
+	Repetition   string
+	Escape       string
+	Subcomponent string
+}
+
+var defaultHL7Delimiters = hl7Delimiters{"|", "^", "~", "\\", "&"}
+
+//MARK: HL7 v2 Encryption/Decryption
+func EncryptAndSaveHL7File(inpath string, outpath string, master MasterKey, opts EncryptOptions) (err error) {
+	fieldRefs, err := parseHL7FieldRefs(opts.Schema.DetFields)
+	if err != nil {
+		return
+	}
+
+	dateRefs, err := parseHL7FieldRefs(opts.Schema.DateFields)
+	if err != nil {
+		return
+	}
+
+	segments, terminator, err := readHL7File(inpath)
+	if err != nil {
+		return
+	}
+
+	// each message of a batch is shifted and tokenized for its own patient
+	messages := hl7Messages(segments)
+	notes := make([]noteContext, len(messages))
+	for m, message := range messages {
+		notes[m] = noteContext{File: inpath, Patient: hl7MessagePatient(message, opts.Schema.hl7PatientField(), inpath), Record: "OBX"}
+
+		err = ApplyCryptorToHL7Fields(message, dateRefs, newDateShifter(master, opts, notes[m].Patient).Field)
+		if err != nil {
+			return
+		}
+	}
+
+	if opts.DateORE {
+		noteDateRefs, refErr := parseHL7FieldRefs(opts.Schema.noteDateFields("hl7"))
+		if refErr != nil {
+			return refErr
+		}
+
+		err = ApplyCryptorToHL7Fields(segments, noteDateRefs, oreDates(master))
+		if err != nil {
+			return
+		}
+	}
+
+	fieldKey := master.FieldKey()
+	err = ApplyCryptorToHL7Fields(segments, fieldRefs, func(value string) (string, error) {
+		return encryptField(fieldKey, value)
+	})
+	if err != nil {
+		return
+	}
+
+	for m, message := range messages {
+		note := notes[m]
+		err = ApplyCryptorToHL7(message, func(text string) (string, error) {
+			payload, encErr := encryptFreeText(master, opts, note, text)
+			if encErr != nil {
+				return "", encErr
+			}
+
+			return encodeCompactPayload(payload), nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = writeHL7File(outpath, segments, terminator)
+	return
+}
+
+func DecryptAndSaveHL7File(inpath string, outpath string, keywordKeys []pks.PrivateKey, freqOuter []byte, opts DecryptOptions) (err error) {
+	segments, terminator, err := readHL7File(inpath)
+	if err != nil {
+		return
+	}
+
+	stats := make(map[string]int)
+	statsMutex := &sync.Mutex{}
+	err = ApplyCryptorToHL7(segments, func(cell string) (string, error) {
+		payload, decodeErr := decodeCompactPayload(cell)
+		if decodeErr != nil {
+			return "", decodeErr
+		}
+
+		text, hits := decryptFreeText(payload, keywordKeys, freqOuter, opts)
+
+		statsMutex.Lock()
+		for _, w := range hits {
+			stats[w] += 1
+		}
+		statsMutex.Unlock()
+
+		return text, nil
+	})
+	if err != nil {
+		return
+	}
+
+	color.Green("-- stats on %s --", inpath)
+	printStats(stats)
+
+	err = writeHL7File(outpath, segments, terminator)
+	return
+}
+
+// ApplyCryptorToHL7 replaces the text of every narrative OBX-5 with the
+// cryptor's output, leaving the rest of each segment untouched. The cryptor
+// sees unescaped text, with repetitions joined by newlines.
+func ApplyCryptorToHL7(segments []string, cryptor func(string) (string, error)) (err error) {
+	return applyCryptorToHL7Notes(segments, func(segment int, text string) (string, error) {
+		return cryptor(text)
+	})
+}
+
+// applyCryptorToHL7Notes is ApplyCryptorToHL7, also telling the cryptor the
+// index of the OBX segment.
+func applyCryptorToHL7Notes(segments []string, cryptor func(int, string) (string, error)) (err error) {
+	delims := defaultHL7Delimiters
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "MSH") && len(segment) >= 8 {
+			delims = hl7Delimiters{segment[3:4], segment[4:5], segment[5:6], segment[6:7], segment[7:8]}
+			continue
+		}
+
+		if !strings.HasPrefix(segment, "OBX"+delims.Field) {
+			continue
+		}
+
+		fields := strings.Split(segment, delims.Field)
+		if len(fields) < 6 || !hl7TextTypes[fields[2]] {
+			continue
+		}
+
+		text := delims.unescape(strings.Replace(fields[5], delims.Repetition, "\n", -1))
+
+		var result string
+		result, err = cryptor(i, text)
+		if err != nil {
+			return
+		}
+
+		fields[5] = delims.escape(result)
+		segments[i] = strings.Join(fields, delims.Field)
+	}
+
+	return
+}
+
+// hl7Messages splits segments into their messages, each starting at its
+// MSH. The messages share the segments' storage, so cryptors applied to a
+// message change the segments.
+func hl7Messages(segments []string) (messages [][]string) {
+	start := 0
+	for i, segment := range segments {
+		if i > start && strings.HasPrefix(segment, "MSH") {
+			messages = append(messages, segments[start:i:i])
+			start = i
+		}
+	}
+
+	if start < len(segments) {
+		messages = append(messages, segments[start:])
+	}
+	return
+}
+
+// hl7MessagePatient identifies the patient of one message by its PID, falling
+// back to the file name.
+func hl7MessagePatient(message []string, patientField string, filepath string) string {
+	if id, ok := hl7PatientID(message, patientField); ok {
+		return id
+	}
+	return defaultPatientID(filepath)
+}
+
+// hl7PatientID reads the first component of a field like "PID-3"
+func hl7PatientID(segments []string, patientField string) (id string, ok bool) {
+	if patientField == "" {
+		return
+	}
+
+	refs, err := parseHL7FieldRefs([]string{patientField})
+	if err != nil {
+		return
+	}
+
+	ApplyCryptorToHL7Fields(segments, refs, func(value string) (string, error) {
+		if !ok && value != "" {
+			id, ok = value, true
+		}
+		return value, nil
+	})
+
+	return
+}
+
+// hl7SegmentField reads the first non-empty field among refs, in a segment
+// or the header of its message, e.g. "OBX-14" or "MSH-7".
+func hl7SegmentField(segments []string, index int, refs []hl7FieldRef) (value string, ok bool) {
+	message := []string{segments[index]}
+	for i := index - 1; i >= 0; i-- {
+		if strings.HasPrefix(segments[i], "MSH") {
+			message = []string{segments[i], segments[index]}
+			break
+		}
+	}
+
+	for _, ref := range refs {
+		ApplyCryptorToHL7Fields(message, []hl7FieldRef{ref}, func(v string) (string, error) {
+			if !ok && v != "" {
+				value, ok = v, true
+			}
+			return v, nil
+		})
+	}
+
+	return
+}
+
+//MARK: HL7 escaping
+func (d hl7Delimiters) unescape(text string) string {
+	e := d.Escape
+	replacer := strings.NewReplacer(
+		e+"F"+e, d.Field,
+		e+"S"+e, d.Component,
+		e+"R"+e, d.Repetition,
+		e+"T"+e, d.Subcomponent,
+		e+"E"+e, d.Escape,
+		e+".br"+e, "\n",
+	)
+
+	return replacer.Replace(text)
+}
+
+func (d hl7Delimiters) escape(text string) string {
+	e := d.Escape
+	replacer := strings.NewReplacer(
+		d.Escape, e+"E"+e,
+		d.Field, e+"F"+e,
+		d.Component, e+"S"+e,
+		d.Repetition, e+"R"+e,
+		d.Subcomponent, e+"T"+e,
+		"\n", e+".br"+e,
+	)
+
+	return replacer.Replace(text)
+}
+
+//MARK: hl7 io
+
+// readHL7File splits a file of one or more messages into segments, returning
+// the segment terminator it found so it can be written back the same way.
+func readHL7File(filepath string) (segments []string, terminator string, err error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+
+	text := string(data)
+	switch {
+	case strings.Contains(text, "\r\n"):
+		terminator = "\r\n"
+	case strings.Contains(text, "\r"):
+		terminator = "\r"
+	default:
+		terminator = "\n"
+	}
+
+	for _, segment := range strings.Split(text, terminator) {
+		if len(strings.TrimSpace(segment)) == 0 {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	return
+}
+
+func writeHL7File(filepath string, segments []string, terminator string) (err error) {
+	data := strings.Join(segments, terminator) + terminator
+	err = ioutil.WriteFile(filepath, []byte(data), 0660)
+	return
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+const testHL7Batch = "MSH|^~\\&|RAD|HOSP|||20120101||ORU^R01|1|P|2.3\r" +
+	"PID|1||P1^^^H||Doe^John\r" +
+	"OBX|1|TX|IMP^Impression||Small nodule\\.br\\no effusion||||||F|||20120105\r" +
+	"MSH|^~\\&|RAD|HOSP|||20120101||ORU^R01|2|P|2.3\r" +
+	"PID|1||P2^^^H||Roe^Jane\r" +
+	"OBX|1|TX|IMP^Impression||stable nodule||||||F|||20120105\r"
+
+func TestHL7RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-hl7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	inpath := path.Join(dir, "batch.hl7")
+	ioutil.WriteFile(inpath, []byte(testHL7Batch), 0660)
+
+	schema := defaultSchema
+	schema.PatientField = "PID-3"
+	schema.DateFields = []string{"OBX-14"}
+	opts := EncryptOptions{Schema: schema, DateMode: "shift"}
+
+	err = EncryptAndSaveHL7File(inpath, inpath+".enc", master, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _, err := readHL7File(inpath + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each message is shifted by its own patient's offset
+	messages := hl7Messages(segments)
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+
+	for m, patient := range []string{"P1", "P2"} {
+		expected, _ := newDateShifter(master, opts, patient).Field("20120105")
+		if date, _ := hl7SegmentField(messages[m], 2, []hl7FieldRef{{"OBX", 14}}); date != expected {
+			t.Fatalf("Message %d of %s dated %s, expected %s", m, patient, date, expected)
+		}
+	}
+
+	err = DecryptAndSaveHL7File(inpath+".enc", inpath+".dec", []pks.PrivateKey{master.KeywordKey.Extract("nodule")}, master.FrequencyKey.OuterKey, DecryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UncoverAndSaveFieldsFile(inpath+".dec", inpath+".unc", master, "hl7", schema, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uncovered, _, err := readHL7File(inpath + ".unc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{2, 5} {
+		if !strings.Contains(uncovered[i], "nodule") || !strings.HasSuffix(uncovered[i], "|20120105") {
+			t.Fatalf("Unexpected decrypted segment: %s", uncovered[i])
+		}
+	}
+}
+
+func TestHL7DefaultPatientField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-hl7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	inpath := path.Join(dir, "batch.hl7")
+	ioutil.WriteFile(inpath, []byte(testHL7Batch), 0660)
+
+	// without a patient field, messages are keyed by their PID-3
+	schema := defaultSchema
+	schema.DateFields = []string{"OBX-14"}
+	opts := EncryptOptions{Schema: schema, DateMode: "shift"}
+
+	err = EncryptAndSaveHL7File(inpath, inpath+".enc", master, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _, err := readHL7File(inpath + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := hl7Messages(segments)
+	for m, patient := range []string{"P1", "P2"} {
+		expected, _ := newDateShifter(master, opts, patient).Field("20120105")
+		if date, _ := hl7SegmentField(messages[m], 2, []hl7FieldRef{{"OBX", 14}}); date != expected {
+			t.Fatalf("Message %d of %s dated %s, expected %s", m, patient, date, expected)
+		}
+	}
+
+	notes, err := readPlainNotes(inpath, "hl7", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Patient != "P1" || notes[1].Patient != "P2" {
+		t.Fatalf("Unexpected patients: %v", notes)
+	}
+}
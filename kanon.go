@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// rareTag replaces frequency tags that occur for fewer than k patients
+const rareTag = "[rare]"
+
+//MARK: k-anonymity
+
+// tagSupport counts the distinct patients or notes each frequency tag
+// occurs in. Patients are told apart by their IDs, so the patients of one
+// tabular or HL7 file count separately, and one patient's files once.
+func tagSupport(dirpath string, format string, schema Schema, freqOuter []byte, opts DecryptOptions, unit string) (support map[string]int, err error) {
+	if unit != "patient" && unit != "note" {
+		err = errors.New(fmt.Sprintf("Unknown k-anonymity unit: %s. Expected one of: patient, note", unit))
+		return
+	}
+
+	support = make(map[string]int)
+	patients := make(map[string]map[string]bool)
+	err = decryptCorpus(dirpath, format, schema, nil, freqOuter, opts, func(file corpusFile) error {
+		for n, note := range file.Notes {
+			noteTags := make(map[string]bool)
+			for _, t := range note.Tags {
+				noteTags[t] = true
+			}
+
+			for t := range noteTags {
+				if unit == "note" {
+					support[t] += 1
+					continue
+				}
+
+				if patients[t] == nil {
+					patients[t] = make(map[string]bool)
+				}
+				patients[t][file.Patients[n]] = true
+			}
+		}
+		return nil
+	})
+
+	for t, ids := range patients {
+		support[t] = len(ids)
+	}
+
+	return
+}
+
+// rareTags are the tags with support below k
+func rareTags(support map[string]int, k int) map[string]bool {
+	rare := make(map[string]bool)
+	for t, c := range support {
+		if c < k {
+			rare[t] = true
+		}
+	}
+
+	return rare
+}
+
+// applyRareTags collapses or suppresses the rare tags of a decrypted note.
+// Tokens revealed by a keyword key are always kept.
+func applyRareTags(note decryptedNote, rare map[string]bool, suppress bool) (result decryptedNote) {
+	for i, t := range note.Tags {
+		if note.Keywords[i] == "" && rare[t] {
+			if suppress {
+				continue
+			}
+			t = rareTag
+		}
+
+		result.Tags = append(result.Tags, t)
+		result.Keywords = append(result.Keywords, note.Keywords[i])
+		result.Assertions = append(result.Assertions, note.Assertions[i])
+		result.Sections = append(result.Sections, note.Sections[i])
+	}
+
+	return
+}
+
+// thresholdNoisyCounts applies k to already noisy counts, which as
+// post-processing keeps their differential privacy. Collapsed counts are
+// summed into a single rare row.
+func thresholdNoisyCounts(released []noisyCount, k int, suppress bool) (result []noisyCount) {
+	rareCount := 0.0
+	for _, nc := range released {
+		if nc.Count >= float64(k) {
+			result = append(result, nc)
+		} else {
+			rareCount += nc.Count
+		}
+	}
+
+	if !suppress && rareCount > 0 {
+		result = append(result, noisyCount{rareTag, rareCount})
+		sort.Slice(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	}
+
+	return
+}
+
+// parseRareMode reads the -k-mode flag, returning whether to suppress
+func parseRareMode(mode string) (suppress bool, err error) {
+	switch mode {
+	case "collapse", "":
+	case "suppress":
+		suppress = true
+	default:
+		err = errors.New(fmt.Sprintf("Unknown '-k-mode' %s. Expected one of: collapse, suppress", mode))
+	}
+
+	return
+}
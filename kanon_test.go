@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestTagSupportCountsPatients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-kanon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	schema := defaultSchema
+	schema.PatientColumn = "pid"
+
+	// p1 has notes in both files, and each file holds several patients
+	files := map[string]string{
+		"a.csv": "pid,note_text\np1,stemi\np2,stemi\np3,nodule\n",
+		"b.csv": "pid,note_text\np1,stemi\n",
+	}
+
+	encDir := path.Join(dir, "enc")
+	os.MkdirAll(encDir, 0777)
+	for name, data := range files {
+		inpath := path.Join(dir, name)
+		ioutil.WriteFile(inpath, []byte(data), 0660)
+
+		err = EncryptAndSaveTableFile(inpath, path.Join(encDir, name+".enc"), master, EncryptOptions{Schema: schema})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	support, err := tagSupport(encDir, "csv", schema, master.FrequencyKey.OuterKey, DecryptOptions{}, "patient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stemi := tagOf(master, "stemi")
+	nodule := tagOf(master, "nodule")
+	if len(support) != 2 || support[stemi] != 2 || support[nodule] != 1 {
+		t.Fatalf("Unexpected patient support: %v", support)
+	}
+
+	support, err = tagSupport(encDir, "csv", schema, master.FrequencyKey.OuterKey, DecryptOptions{}, "note")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if support[stemi] != 3 || support[nodule] != 1 {
+		t.Fatalf("Unexpected note support: %v", support)
+	}
+}
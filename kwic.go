@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+// kwicHit is one keyword hit with the tokens around it
+type kwicHit struct {
+	File      string   `json:"file"`
+	Record    string   `json:"record"`
+	Note      int      `json:"note"`
+	Position  int      `json:"position"`
+	Keyword   string   `json:"keyword"`
+	Assertion string   `json:"assertion,omitempty"`
+	Section   string   `json:"section,omitempty"`
+	Left      []string `json:"left"`
+	Right     []string `json:"right"`
+}
+
+// tagAlias is a short name for a frequency tag, stable across runs and
+// corpora since it only depends on the tag. Revealed keywords and [rare]
+// are shown as is.
+func tagAlias(note decryptedNote, i int) string {
+	if note.Keywords[i] != "" {
+		return note.Keywords[i]
+	}
+
+	tag := note.Tags[i]
+	switch tag {
+	case "":
+		return "_"
+	case rareTag:
+		return tag
+	}
+
+	alias, _ := base36.Encode(cryptutil.SHA2([]byte(tag))[:5])
+	if len(alias) > 6 {
+		alias = alias[:6]
+	}
+	return "#" + alias
+}
+
+//MARK: Concordance
+
+// kwicHits finds the keyword hits of a decrypted file, with up to width
+// tokens of context on each side, grouped by record type.
+func kwicHits(file corpusFile, width int) (hits []kwicHit) {
+	for n, note := range file.Notes {
+		for i, keyword := range note.Keywords {
+			if keyword == "" {
+				continue
+			}
+
+			hit := kwicHit{
+				File:      path.Base(file.Path),
+				Record:    file.Records[n],
+				Note:      n,
+				Position:  i,
+				Keyword:   keyword,
+				Assertion: note.Assertions[i],
+				Section:   note.Sections[i],
+				Left:      []string{},
+				Right:     []string{},
+			}
+
+			for j := i - width; j < i; j++ {
+				if j >= 0 {
+					hit.Left = append(hit.Left, tagAlias(note, j))
+				}
+			}
+			for j := i + 1; j <= i+width && j < len(note.Tags); j++ {
+				hit.Right = append(hit.Right, tagAlias(note, j))
+			}
+
+			hits = append(hits, hit)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Record < hits[j].Record })
+	return
+}
+
+// writeKWIC prints the hits of a file, a heading per record type and a line
+// per hit with the keyword centered.
+func writeKWIC(w io.Writer, file string, hits []kwicHit) (err error) {
+	if len(hits) == 0 {
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "== %s ==\n", file)
+	if err != nil {
+		return
+	}
+
+	leftWidth := 0
+	for _, hit := range hits {
+		if l := len(strings.Join(hit.Left, " ")); l > leftWidth {
+			leftWidth = l
+		}
+	}
+
+	record := ""
+	for i, hit := range hits {
+		if i == 0 || hit.Record != record {
+			record = hit.Record
+			fmt.Fprintf(w, "-- %s --\n", record)
+		}
+
+		line := fmt.Sprintf("  %*s [%s] %s", leftWidth, strings.Join(hit.Left, " "), assertedKeyword(hit.Keyword, hit.Assertion), strings.Join(hit.Right, " "))
+		_, err = fmt.Fprintln(w, strings.TrimRight(line, " "))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func writeKWICJSON(w io.Writer, hits []kwicHit) (err error) {
+	if hits == nil {
+		hits = []kwicHit{}
+	}
+
+	data, err := json.MarshalIndent(hits, "", "  ")
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testKWICNote is a decrypted note of tags, some revealed as keywords
+func testKWICNote(tags []string, keywords []string) decryptedNote {
+	n := len(tags)
+	return decryptedNote{Tags: tags, Keywords: keywords, Assertions: make([]string, n), Sections: make([]string, n)}
+}
+
+func TestKWICContextEdges(t *testing.T) {
+	file := corpusFile{Path: "/enc/a.json.enc", Records: []string{"Car"}, Notes: []decryptedNote{
+		testKWICNote([]string{"t1", "t2", "t3"}, []string{"stemi", "", "pain"}),
+	}}
+
+	hits := kwicHits(file, 2)
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits, got %v", hits)
+	}
+
+	// the context stops at the edges of the note, and shows revealed keywords
+	first, last := hits[0], hits[1]
+	if len(first.Left) != 0 || len(first.Right) != 2 || first.Right[1] != "pain" {
+		t.Fatalf("Unexpected context of the first token: %v %v", first.Left, first.Right)
+	}
+	if len(last.Right) != 0 || len(last.Left) != 2 || last.Left[0] != "stemi" || last.Left[1] != tagAlias(file.Notes[0], 1) {
+		t.Fatalf("Unexpected context of the last token: %v %v", last.Left, last.Right)
+	}
+	if first.File != "a.json.enc" || first.Position != 0 || last.Position != 2 {
+		t.Fatalf("Unexpected hits: %v", hits)
+	}
+}
+
+func TestTagAliases(t *testing.T) {
+	a := testKWICNote([]string{"tag1", "tag2", "", rareTag}, make([]string, 4))
+	b := testKWICNote([]string{"tag2", "tag1"}, make([]string, 2))
+
+	// the same tag has the same alias in every note and file
+	if tagAlias(a, 0) != tagAlias(b, 1) || tagAlias(a, 1) != tagAlias(b, 0) {
+		t.Fatal("Aliases of the same tag differ")
+	}
+	if tagAlias(a, 0) == tagAlias(a, 1) {
+		t.Fatal("Different tags have the same alias")
+	}
+
+	if alias := tagAlias(a, 0); !strings.HasPrefix(alias, "#") || len(alias) > 7 {
+		t.Fatalf("Unexpected alias: %s", alias)
+	}
+	if tagAlias(a, 2) != "_" || tagAlias(a, 3) != rareTag {
+		t.Fatalf("Unexpected aliases: %s %s", tagAlias(a, 2), tagAlias(a, 3))
+	}
+}
+
+func TestKWICGroupsByRecord(t *testing.T) {
+	file := corpusFile{Path: "/enc/a.json.enc", Records: []string{"Rad", "Car", "Rad"}, Notes: []decryptedNote{
+		testKWICNote([]string{"t1", "t2"}, []string{"", "nodule"}),
+		testKWICNote([]string{"t3"}, []string{"stemi"}),
+		testKWICNote([]string{"t4"}, []string{"effusion"}),
+	}}
+
+	hits := kwicHits(file, 1)
+	if len(hits) != 3 || hits[0].Record != "Car" || hits[1].Keyword != "nodule" || hits[2].Keyword != "effusion" {
+		t.Fatalf("Hits not grouped by record type in note order: %v", hits)
+	}
+
+	var out bytes.Buffer
+	if err := writeKWIC(&out, hits[0].File, hits); err != nil {
+		t.Fatal(err)
+	}
+
+	alias := tagAlias(file.Notes[0], 0)
+	expected := "== a.json.enc ==\n-- Car --\n  " + strings.Repeat(" ", len(alias)) + " [stemi]\n-- Rad --\n  " + alias + " [nodule]\n  " + strings.Repeat(" ", len(alias)) + " [effusion]\n"
+	if out.String() != expected {
+		t.Fatalf("Wrote %q, expected %q", out.String(), expected)
+	}
+}
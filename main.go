@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"path"
@@ -14,6 +16,8 @@ import (
 	"os"
 
 	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/dp"
 	"github.com/agrinman/alvis/pfs"
 	"github.com/agrinman/alvis/pks"
 
@@ -31,6 +35,21 @@ type MasterKey struct {
 	FrequencyKey pfs.MasterKey
 }
 
+// subKey derives an independent key for the given purpose, so new features
+// don't need new fields in existing master key files.
+func (msk MasterKey) subKey(label string) []byte {
+	seed := make([]byte, 0, len(msk.KeywordKey.Key)+len(msk.FrequencyKey.InnerKey))
+	seed = append(seed, msk.KeywordKey.Key...)
+	seed = append(seed, msk.FrequencyKey.InnerKey...)
+
+	return cryptutil.H([]byte(label), seed)
+}
+
+// FieldKey is the deterministic encryption key for structured fields
+func (msk MasterKey) FieldKey() []byte {
+	return msk.subKey("alvis-field")
+}
+
 func parseMasterKey(filepath string) (msk MasterKey, err error) {
 	mskBytes, err := ioutil.ReadFile(filepath)
 	if err != nil {
@@ -46,14 +65,30 @@ func parseMasterKey(filepath string) (msk MasterKey, err error) {
 	return
 }
 
-func parsePrivateKey(filepath string) (privateKey pks.PrivateKey, err error) {
+// parsePrivateKeys reads a key file: one keyword key, or a list of them
+// for fuzzy keys, opening it if it was sealed.
+func parsePrivateKeys(filepath string, opener envelopeKey) (privateKeys []pks.PrivateKey, err error) {
 	kpBytes, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return
 	}
 
+	if envelope, ok := parseEnvelope(kpBytes); ok {
+		kpBytes, err = openEnvelope(envelope, keywordKeyFormat, opener)
+		if err != nil {
+			return
+		}
+	}
+
+	if trimmed := bytes.TrimSpace(kpBytes); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &privateKeys)
+		return
+	}
+
 	// unmarshall private key
+	var privateKey pks.PrivateKey
 	err = json.Unmarshal(kpBytes, &privateKey)
+	privateKeys = []pks.PrivateKey{privateKey}
 
 	return
 }
@@ -95,7 +130,7 @@ func genMaster(c *cli.Context) (err error) {
 
 func genKeywordKey(c *cli.Context) (err error) {
 	if c.NumFlags() < 3 {
-		color.Red("Missing parameters: \n\t-msk for path to master secret key \n\t-words a file containing keywords on each file  \n\t-out-dir directory path where secret keys will be written to")
+		color.Red("Missing parameters: \n\t-msk for path to master secret key \n\t-words a file containing keywords on each file  \n\t-out-dir directory path where secret keys will be written to, or -bundle and -to or -bundle-key for a key bundle")
 		return
 	}
 
@@ -124,6 +159,19 @@ func genKeywordKey(c *cli.Context) (err error) {
 	wordTokens := strings.Split(string(words), "\n")
 
 	fmt.Println(wordTokens)
+
+	// the analyst keys are sealed to, if any
+	to, err := sealTo(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// or write all keys into one bundle sealed to the analyst
+	if bundlePath := c.String("bundle"); bundlePath != "" {
+		return writeBundle(master, wordTokens, bundlePath, to)
+	}
+
 	// create sk for all the words
 	for _, w := range wordTokens {
 		w = strings.TrimSpace(w)
@@ -131,7 +179,17 @@ func genKeywordKey(c *cli.Context) (err error) {
 			continue
 		}
 
-		secretKey := master.KeywordKey.Extract(w)
+		keys, extractErr := extractKeys(master, w)
+		if extractErr != nil {
+			color.Red(extractErr.Error())
+			continue
+		}
+
+		// fuzzy keywords get a list of keys
+		var secretKey interface{} = keys
+		if keywordKind(w) != "fuzzy" {
+			secretKey = keys[0]
+		}
 
 		outBytes, err := json.Marshal(secretKey)
 		if err != nil {
@@ -139,6 +197,14 @@ func genKeywordKey(c *cli.Context) (err error) {
 			continue
 		}
 
+		if to.isSet() {
+			outBytes, err = sealEnvelope(keywordKeyFormat, outBytes, to)
+			if err != nil {
+				color.Red(err.Error())
+				continue
+			}
+		}
+
 		fpath := path.Join(outPath, fmt.Sprintf("%s.sk", w))
 
 		// write file
@@ -148,6 +214,212 @@ func genKeywordKey(c *cli.Context) (err error) {
 	return
 }
 
+func writeBundle(master MasterKey, wordTokens []string, bundlePath string, to envelopeKey) (err error) {
+	if !to.isSet() {
+		color.Red("Missing '-to' for the analyst key the bundle is sealed to, from 'identity', or '-bundle-key' from 'extract bundle-key'")
+		return
+	}
+
+	var words []string
+	for _, w := range wordTokens {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, w)
+		}
+	}
+
+	bundle, err := newKeyBundle(master, words)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	err = writeKeyBundle(bundle, to, bundlePath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	color.Green("Wrote %d keys of %d keywords to %s", len(bundle.Keys), len(bundle.Index), bundlePath)
+	return
+}
+
+// sealTo reads the key extracted keys are sealed to: an analyst's public
+// key from -to, or a bundle key from -bundle-key. Without either, keys are
+// written in the clear.
+func sealTo(c *cli.Context) (to envelopeKey, err error) {
+	toPath, bundleKeyPath := c.String("to"), c.String("bundle-key")
+	if toPath != "" && bundleKeyPath != "" {
+		err = errors.New("Give only one of '-to' and '-bundle-key'")
+		return
+	}
+
+	if toPath != "" {
+		to, err = readEnvelopeKey(toPath)
+		if err == nil && to.Public == nil {
+			err = errors.New(fmt.Sprintf("%s is not an analyst key, from 'identity'", toPath))
+		}
+		return
+	}
+
+	if bundleKeyPath != "" {
+		to.Bundle, err = readBundleKey(bundleKeyPath)
+	}
+	return
+}
+
+// keyOpener reads the bundle key and analyst identity, if given, that open
+// sealed key files.
+func keyOpener(c *cli.Context) (opener envelopeKey, err error) {
+	if bundleKeyPath := c.String("bundle-key"); bundleKeyPath != "" {
+		opener.Bundle, err = readBundleKey(bundleKeyPath)
+		if err != nil {
+			return
+		}
+	}
+
+	if identityPath := c.String("identity"); identityPath != "" {
+		identity, idErr := readEnvelopeKey(identityPath)
+		if idErr != nil {
+			return opener, idErr
+		}
+
+		if identity.Private == nil {
+			err = errors.New(fmt.Sprintf("%s is not an analyst identity, from 'identity'", identityPath))
+			return
+		}
+		opener.Public, opener.Private = identity.Public, identity.Private
+	}
+
+	return
+}
+
+func genIdentity(c *cli.Context) (err error) {
+	if c.NumFlags() < 1 {
+		color.Red("Missing '-out' flag for the path of the analyst key, without extension")
+		return
+	}
+
+	identity, public, err := newAnalystKey()
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	identityBytes, err := json.Marshal(identity)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	publicBytes, err := json.Marshal(public)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// the identity stays with the analyst, the public key is shared
+	outPath := c.String("out")
+	err = ioutil.WriteFile(outPath+".id", identityBytes, 0600)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	err = ioutil.WriteFile(outPath+".pub", publicBytes, 0644)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	color.Green("Wrote identity %s.id and public key %s.pub of analyst key %s", outPath, outPath, keyID(public.Public))
+	return
+}
+
+func genBundleKey(c *cli.Context) (err error) {
+	if c.NumFlags() < 1 {
+		color.Red("Missing '-out' flag for filepath of the bundle key")
+		return
+	}
+
+	bundleKey, err := cryptutil.RandKey()
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// write file
+	err = ioutil.WriteFile(c.String("out"), bundleKey, 0600)
+
+	return
+}
+
+func genRangeKey(c *cli.Context) (err error) {
+	if c.NumFlags() < 3 || !(c.IsSet("min") || c.IsSet("max")) {
+		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-out flag for filepath of the range key \n\t-min and/or -max for the range")
+		return
+	}
+
+	// read master secret file
+	master, err := parseMasterKey(c.String("msk"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	lo, hi, err := rangeKeyBounds(c.IsSet("min"), c.Float64("min"), c.IsSet("max"), c.Float64("max"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	label := c.String("label")
+	if label == "" {
+		label = rangeLabel(lo, hi)
+	}
+
+	rangeKey, err := master.RangeKey().Extract(strings.ToLower(label), lo, hi)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	outBytes, err := json.Marshal(rangeKey)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// write file
+	err = ioutil.WriteFile(c.String("out"), outBytes, 0660)
+
+	return
+}
+
+func genSectionKey(c *cli.Context) (err error) {
+	if c.NumFlags() < 3 {
+		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-label for the section, e.g. impression \n\t-out flag for filepath of the section key")
+		return
+	}
+
+	// read master secret file
+	master, err := parseMasterKey(c.String("msk"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	outBytes, err := json.Marshal(extractSectionKey(master, c.String("label")))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// write file
+	err = ioutil.WriteFile(c.String("out"), outBytes, 0660)
+
+	return
+}
+
 func genFrequencyKey(c *cli.Context) (err error) {
 	if c.NumFlags() < 2 {
 		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-out flag for filepath of search keyword secret key")
@@ -165,6 +437,21 @@ func genFrequencyKey(c *cli.Context) (err error) {
 
 	outBytes := master.FrequencyKey.OuterKey
 
+	// seal it to the analyst, if any
+	to, err := sealTo(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	if to.isSet() {
+		outBytes, err = sealEnvelope(frequencyKeyFormat, outBytes, to)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+	}
+
 	// get outPath
 	outPath := c.String("out")
 
@@ -174,204 +461,1215 @@ func genFrequencyKey(c *cli.Context) (err error) {
 	return
 }
 
-func encrypt(c *cli.Context) (err error) {
+func encrypt(c *cli.Context) (err error) {
+	if c.NumFlags() < 3 {
+		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-data-dir for directory of patient files \n\t-out-dir for the directory of the encrypted patient files")
+		return
+	}
+
+	// read master secret file
+	mskPath := c.String("msk")
+	master, err := parseMasterKey(mskPath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read data format
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	opts := EncryptOptions{
+		Schema:      schema,
+		DateMode:    c.String("date-mode"),
+		DateBucket:  c.String("date-bucket"),
+		DateORE:     c.Bool("date-ore"),
+		Ranges:      c.Bool("range"),
+		Assertions:  c.Bool("assertions"),
+		Sections:    c.String("sections"),
+		PadLength:   c.Int("pad-length"),
+		TokenBucket: c.Int("pad-tokens"),
+	}
+
+	if c.Bool("scrub-phi") || c.String("phi-rules") != "" {
+		opts.PHI, err = parsePHIConfig(c.String("phi-rules"))
+		if err != nil {
+			color.Red("Cannot read PHI rules: %s", err)
+			return
+		}
+	}
+
+	if lengths := c.String("prefix-lengths"); lengths != "" {
+		opts.PrefixLengths, err = parsePrefixLengths(lengths)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+	}
+
+	switch opts.Sections {
+	case "", sectionsPlain, sectionsEncrypted:
+	default:
+		color.Red("Unknown '-sections' %s. Expected one of: plain, encrypted", opts.Sections)
+		return
+	}
+
+	opts.FuzzyDistance = c.Int("fuzzy")
+	if opts.FuzzyDistance < 0 || opts.FuzzyDistance > fuzzyMaxDistance {
+		color.Red("Invalid '-fuzzy' edit distance: %d. Expected up to %d", opts.FuzzyDistance, fuzzyMaxDistance)
+		return
+	}
+
+	if target := c.Int("smooth"); target > 0 {
+		schedulePath := c.String("schedule")
+		if schedulePath == "" {
+			color.Red("Missing '-schedule' path for the smoothing schedule")
+			return
+		}
+
+		dataFiles, filesErr := getFilePathsIn(c.String("data-dir"))
+		if filesErr != nil {
+			err = filesErr
+			color.Red(err.Error())
+			return
+		}
+
+		counts, countErr := countCorpusTokens(master, dataFiles, format, opts)
+		if countErr != nil {
+			err = countErr
+			color.Red("Cannot count corpus tokens: %s", err)
+			return
+		}
+
+		opts.Smoothing = buildSmoothingSchedule(counts, target)
+
+		err = writeSmoothingSchedule(master, opts.Smoothing, schedulePath)
+		if err != nil {
+			color.Red("Cannot write smoothing schedule: %s", err)
+			return
+		}
+	}
+
+	reportPath := c.String("report")
+	opts.Report = newEncryptReport(reportPath, opts)
+
+	switch opts.DateMode {
+	case "", "none":
+		opts.DateMode = ""
+	case "shift", "bucket":
+	default:
+		color.Red("Unknown '-date-mode' %s. Expected one of: none, shift, bucket", opts.DateMode)
+		return
+	}
+
+	// get and mkdir out path
+	outPath := c.String("out-dir")
+	os.MkdirAll(outPath, 0777)
+
+	// read patient files
+	patientDirPath := c.String("data-dir")
+
+	file, _ := os.Open(patientDirPath)
+	fi, err := file.Stat()
+	if err != nil {
+		color.Red("Cannot get file info: %s", err)
+		return
+	}
+
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		files, _ := ioutil.ReadDir(patientDirPath)
+
+		for _, f := range files {
+			in := path.Join(patientDirPath, f.Name())
+			out := path.Join(outPath, f.Name()+".enc")
+
+			opts.Report.addFile()
+
+			switch format {
+			case "csv":
+				err = EncryptAndSaveTableFile(in, out, master, opts)
+			case "fhir":
+				err = EncryptAndSaveFHIRFile(in, out, master, opts)
+			case "hl7":
+				err = EncryptAndSaveHL7File(in, out, master, opts)
+			case "json", "":
+				err = EncryptAndSavePatientFile(in, out, master, opts)
+			default:
+				color.Red("Unknown '-format' %s. Expected one of: json, csv, fhir, hl7", format)
+				return
+			}
+			if err != nil {
+				color.Red("Cannot EncryptAndSavePatientFile: %s", err)
+				return
+			}
+		}
+
+	case mode.IsRegular():
+		color.Red("'-data-dir' was given a file. expected a directory.")
+		return
+	}
+
+	if opts.PHI != nil {
+		color.Green("-- redacted PHI --")
+		printStats(opts.Report.redactionTotals())
+	}
+
+	opts.Report.printLeakage(os.Stderr)
+
+	if reportPath != "" {
+		err = opts.Report.write(reportPath)
+		if err != nil {
+			color.Red("Cannot write report: %s", err)
+		}
+	}
+
+	return
+}
+
+// readKeywordKeys reads every keyword key in a directory of keys, or in a
+// key bundle, opening sealed ones with the bundle key or identity.
+func readKeywordKeys(keyDirPath string, opener envelopeKey) (keywordKeys []pks.PrivateKey, err error) {
+	if !isKeyDir(keyDirPath) {
+		if !opener.isSet() {
+			err = errors.New("Error: '-key-dir' was given a key bundle. Missing its '-bundle-key' or '-identity'.")
+			return
+		}
+
+		bundle, bundleErr := readKeyBundle(keyDirPath, opener)
+		if bundleErr != nil {
+			return nil, bundleErr
+		}
+
+		fmt.Fprintf(os.Stderr, "Key bundle of %d keywords from master %s, created %s\n", len(bundle.Index), bundle.Master, bundle.Created)
+		return bundle.Keys, nil
+	}
+
+	files, err := ioutil.ReadDir(keyDirPath)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Cannot read %s. Error: %s", keyDirPath, err))
+		return
+	}
+
+	//read all keys
+	for _, f := range files {
+		fpath := path.Join(keyDirPath, f.Name())
+		if ext := path.Ext(fpath); ext == rangeKeyExt || ext == sectionKeyExt {
+			continue
+		}
+
+		// try to parse as ibe.private key
+		privateKeys, parseErr := parsePrivateKeys(fpath, opener)
+		if parseErr == nil {
+			keywordKeys = append(keywordKeys, privateKeys...)
+		} else {
+			color.Red("Could not parse keyword key %s. Got err: %s", fpath, parseErr)
+		}
+	}
+
+	return
+}
+
+// corpusDecryptOptions reads the -merge-map, -k, -k-unit, -k-mode and
+// -section flags shared by the commands that decrypt a corpus of tags, and
+// the range and section keys of -key-dir.
+func corpusDecryptOptions(c *cli.Context, dirpath string, format string, schema Schema, freqOuter []byte) (opts DecryptOptions, err error) {
+	if keyDirPath := c.String("key-dir"); keyDirPath != "" {
+		opts.RangeKeys, err = readRangeKeys(keyDirPath)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Cannot read range keys: %s", err))
+			return
+		}
+
+		opts.SectionKeys, err = readSectionKeys(keyDirPath)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Cannot read section keys: %s", err))
+			return
+		}
+	}
+
+	for _, section := range c.StringSlice("section") {
+		opts.Sections = append(opts.Sections, sectionLabel(section))
+	}
+
+	if mergePath := c.String("merge-map"); mergePath != "" {
+		opts.MergeMap, err = readMergeMap(mergePath)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Cannot read merge map: %s", err))
+			return
+		}
+	}
+
+	suppress, err := parseRareMode(c.String("k-mode"))
+	if err != nil {
+		return
+	}
+
+	// find the tags seen for fewer than k patients or notes
+	if k := c.Int("k"); k > 1 {
+		unit := c.String("k-unit")
+		if unit == "" {
+			unit = "patient"
+		}
+
+		support, supportErr := tagSupport(dirpath, format, schema, freqOuter, opts, unit)
+		if supportErr != nil {
+			err = errors.New(fmt.Sprintf("Cannot count tag support: %s", supportErr))
+			return
+		}
+
+		opts.RareTags = rareTags(support, k)
+		opts.SuppressRare = suppress
+	}
+
+	return
+}
+
+func decrypt(c *cli.Context) (err error) {
+	if c.NumFlags() < 4 {
+		color.Red("Missing one or more args: \n\t-key-dir for directory path to functional keys \n\t-freq-key for path to the frequency decryption key file \n\t-data-dir for directory of data files \n\t-out-dir for the where to write the partially-decrypted patient files")
+		return
+	}
+
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read freq key
+	freqOuterKey, err := readFrequencyKey(c.String("freq-key"), opener)
+	if err != nil {
+		color.Red("Cannot read freq key: %s", err)
+		return
+	}
+
+	// read all functional keys
+	keywordKeys, err := readKeywordKeys(c.String("key-dir"), opener)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read data format
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	// get and mkdir out path
+	outPath := c.String("out-dir")
+	os.MkdirAll(outPath, 0777)
+
+	// read patient files
+	patientDirPath := c.String("data-dir")
+
+	decryptOpts, err := corpusDecryptOptions(c, patientDirPath, format, schema, freqOuterKey)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	file, _ := os.Open(patientDirPath)
+	fi, err := file.Stat()
+	if err != nil {
+		color.Red("Cannot read %s. Error: %s", patientDirPath, err)
+		return
+	}
+
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		files, _ := ioutil.ReadDir(patientDirPath)
+		for _, f := range files {
+
+			in := path.Join(patientDirPath, f.Name())
+			out := path.Join(outPath, strings.Replace(f.Name(), ".enc", "", 1))
+
+			switch format {
+			case "csv":
+				err = DecryptAndSaveTableFile(in, out, keywordKeys, freqOuterKey, schema, decryptOpts)
+			case "fhir":
+				err = DecryptAndSaveFHIRFile(in, out, keywordKeys, freqOuterKey, decryptOpts)
+			case "hl7":
+				err = DecryptAndSaveHL7File(in, out, keywordKeys, freqOuterKey, decryptOpts)
+			case "json", "":
+				err = DecryptAndSavePatientFile(in, out, keywordKeys, freqOuterKey, decryptOpts)
+			default:
+				color.Red("Unknown '-format' %s. Expected one of: json, csv, fhir, hl7", format)
+				return
+			}
+			if err != nil {
+				color.Red("Cannot EncryptAndSavePatientFile: %s", err)
+				return
+			}
+		}
+	case mode.IsRegular():
+		color.Red("'-data-dir' was given a file. expected a directory.")
+		break
+	}
+
+	return
+}
+
+func decryptFreq(c *cli.Context) (err error) {
+	if c.NumFlags() < 2 {
+		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-c frequency cipher-text \n\t-file multiple frequency cipher-texts in a file")
+		return
+	}
+
+	// read master secret file
+	mskPath := c.String("msk")
+	master, err := parseMasterKey(mskPath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	uncover := pfs.Uncover
+	if c.Bool("padded") {
+		uncover = pfs.UncoverPadded
+	}
+
+	// try if many
+	filePath := c.String("file")
+	if filePath != "" {
+		fileBytes, fileErr := ioutil.ReadFile(filePath)
+		if fileErr != nil {
+			err = fileErr
+			color.Red(err.Error())
+			return
+		}
+
+		fileCopy := string(fileBytes)
+
+		splitter := func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+		}
+		tokens := strings.FieldsFunc(fileCopy, splitter)
+
+		for _, t := range tokens {
+			ctxt, decodeErr := base36.DecodeString(t)
+			if decodeErr != nil {
+				continue
+			}
+
+			ptxt, decryptErr := uncover(master.FrequencyKey, ctxt)
+			if decryptErr != nil {
+				continue
+			}
+
+			fileCopy = strings.Replace(fileCopy, t, string(ptxt), -1)
+		}
+
+		fmt.Println(fileCopy)
+
+		return
+	}
+
+	// otherwise just decrypt one
+	// get and mkdir out path
+	ctxt, err := base36.DecodeString(c.String("c"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	ptxt, err := uncover(master.FrequencyKey, ctxt)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	fmt.Println(string(ptxt))
+	return
+}
+
+func mergeTags(c *cli.Context) (err error) {
+	if c.NumFlags() < 3 {
+		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-schedule for path to the smoothing schedule written by encrypt \n\t-out for path of the merge map")
+		return
+	}
+
+	// read master secret file
+	mskPath := c.String("msk")
+	master, err := parseMasterKey(mskPath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	schedule, err := readSmoothingSchedule(master, c.String("schedule"))
+	if err != nil {
+		color.Red("Cannot read smoothing schedule: %s", err)
+		return
+	}
+
+	merge, err := schedule.mergeMap(master)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	outBytes, err := json.Marshal(merge)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	err = ioutil.WriteFile(c.String("out"), outBytes, 0660)
+	return
+}
+
+func uncoverFields(c *cli.Context) (err error) {
+	if c.NumFlags() < 2 {
+		color.Red("Missing parameters: \n\t-msk for path to master secret key \n\t-c a structured field cipher-text, or \n\t-data-dir for directory of data files \n\t-out-dir for where to write the files with uncovered fields \n\t-schema naming the encrypted fields")
+		return
+	}
+
+	// read master secret file
+	mskPath := c.String("msk")
+	master, err := parseMasterKey(mskPath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// just uncover one
+	if c.String("c") != "" {
+		ptxt, decryptErr := decryptField(master.FieldKey(), c.String("c"))
+		if decryptErr != nil {
+			err = decryptErr
+			color.Red(err.Error())
+			return
+		}
+
+		fmt.Println(ptxt)
+		return
+	}
+
+	// otherwise uncover every file
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	dataFiles, err := getFilePathsIn(c.String("data-dir"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	outPath := c.String("out-dir")
+	os.MkdirAll(outPath, 0777)
+
+	for _, in := range dataFiles {
+		out := path.Join(outPath, path.Base(in))
+
+		err = UncoverAndSaveFieldsFile(in, out, master, format, schema, c.Bool("unshift-dates"))
+		if err != nil {
+			color.Red("Cannot UncoverAndSaveFieldsFile: %s", err)
+			return
+		}
+	}
+
+	return
+}
+
+func printDateOffset(c *cli.Context) (err error) {
+	if c.NumFlags() < 2 {
+		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-patient the patient id (patient field value, or file name without extension)")
+		return
+	}
+
+	// read master secret file
+	mskPath := c.String("msk")
+	master, err := parseMasterKey(mskPath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	fmt.Printf("%d days\n", dateOffset(master, c.String("patient")))
+	return
+}
+
+func freqReport(c *cli.Context) (err error) {
+	if c.NumFlags() < 3 {
+		color.Red("Missing one or more args: \n\t-freq-key for path to the frequency decryption key file \n\t-data-dir for directory of encrypted data files \n\t-ledger for path to the privacy budget ledger")
+		return
+	}
+
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read freq key
+	freqOuterKey, err := readFrequencyKey(c.String("freq-key"), opener)
+	if err != nil {
+		color.Red("Cannot read freq key: %s", err)
+		return
+	}
+
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	decryptOpts := DecryptOptions{}
+	if mergePath := c.String("merge-map"); mergePath != "" {
+		decryptOpts.MergeMap, err = readMergeMap(mergePath)
+		if err != nil {
+			color.Red("Cannot read merge map: %s", err)
+			return
+		}
+	}
+
+	suppress, err := parseRareMode(c.String("k-mode"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	reportOpts := freqReportOptions{
+		Mechanism: c.String("mechanism"),
+		Epsilon:   c.Float64("epsilon"),
+		Delta:     c.Float64("delta"),
+		MaxTags:   c.Int("max-tags"),
+	}
+
+	err = reportOpts.validate()
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// check the budget before looking at the data
+	ledgerPath := c.String("ledger")
+	ledger, err := dp.ReadLedger(ledgerPath, c.Float64("budget"), c.Float64("delta-budget"))
+	if err != nil {
+		color.Red("Cannot read ledger: %s", err)
+		return
+	}
+
+	query := fmt.Sprintf("freq-report %s (%s, max-tags %d)", c.String("data-dir"), reportOpts.Mechanism, reportOpts.MaxTags)
+	err = ledger.Charge(query, reportOpts.Epsilon, reportOpts.Delta)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// count the patients each tag appears in
+	patients := make(patientTags)
+	err = decryptCorpus(c.String("data-dir"), c.String("format"), schema, nil, freqOuterKey, decryptOpts, func(file corpusFile) error {
+		patients.addFile(file)
+		return nil
+	})
+	if err != nil {
+		color.Red("Cannot read corpus: %s", err)
+		return
+	}
+
+	counts, err := patients.counts(reportOpts.MaxTags)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	released, err := releaseNoisyCounts(counts, reportOpts)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	if k := c.Int("k"); k > 1 {
+		released = thresholdNoisyCounts(released, k, suppress)
+	}
+
+	err = ledger.Write(ledgerPath)
+	if err != nil {
+		color.Red("Cannot write ledger: %s", err)
+		return
+	}
+
+	out := os.Stdout
+	if outPath := c.String("out"); outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+		defer out.Close()
+	}
+
+	writeNoisyCounts(out, released)
+
+	spentEpsilon, spentDelta := ledger.Spent()
+	color.Magenta("--- privacy budget ---")
+	fmt.Fprintf(os.Stderr, "spent epsilon %g of %g, delta %g of %g\n", spentEpsilon, ledger.Epsilon, spentDelta, ledger.Delta)
+
+	return
+}
+
+func histogram(c *cli.Context) (err error) {
+	if c.NumFlags() < 2 {
+		color.Red("Missing one or more args: \n\t-freq-key for path to the frequency decryption key file \n\t-data-dir for directory of encrypted data files")
+		return
+	}
+
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read freq key
+	freqOuterKey, err := readFrequencyKey(c.String("freq-key"), opener)
+	if err != nil {
+		color.Red("Cannot read freq key: %s", err)
+		return
+	}
+
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	dataDir := c.String("data-dir")
+	decryptOpts, err := corpusDecryptOptions(c, dataDir, format, schema, freqOuterKey)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	hist := newTagHistogram()
+	err = decryptCorpus(dataDir, format, schema, nil, freqOuterKey, decryptOpts, func(file corpusFile) error {
+		hist.addFile(file)
+		return nil
+	})
+	if err != nil {
+		color.Red("Cannot read corpus: %s", err)
+		return
+	}
+
+	hist.finish(c.Int("top"))
+
+	out := os.Stdout
+	if outPath := c.String("out"); outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+		defer out.Close()
+	}
+
+	if c.Bool("json") {
+		err = hist.writeJSON(out)
+	} else {
+		err = hist.writeCSV(out)
+	}
+	if err != nil {
+		color.Red(err.Error())
+	}
+
+	return
+}
+
+func cooccur(c *cli.Context) (err error) {
+	if c.NumFlags() < 2 {
+		color.Red("Missing one or more args: \n\t-freq-key for path to the frequency decryption key file \n\t-data-dir for directory of encrypted data files")
+		return
+	}
+
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read freq key
+	freqOuterKey, err := readFrequencyKey(c.String("freq-key"), opener)
+	if err != nil {
+		color.Red("Cannot read freq key: %s", err)
+		return
+	}
+
+	// keyword keys are optional: without them every token is a tag
+	var keywordKeys []pks.PrivateKey
+	if keyDirPath := c.String("key-dir"); keyDirPath != "" {
+		keywordKeys, err = readKeywordKeys(keyDirPath, opener)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+	}
+
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	dataDir := c.String("data-dir")
+	decryptOpts, err := corpusDecryptOptions(c, dataDir, format, schema, freqOuterKey)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	if c.Int("window") < 1 {
+		color.Red("'-window' must be at least 1")
+		return
+	}
+
+	matrix := newCooccurrence(c.Int("window"))
+	err = decryptCorpus(dataDir, format, schema, keywordKeys, freqOuterKey, decryptOpts, func(file corpusFile) error {
+		for _, note := range file.Notes {
+			matrix.addNote(note)
+		}
+		return nil
+	})
+	if err != nil {
+		color.Red("Cannot read corpus: %s", err)
+		return
+	}
+
+	out := os.Stdout
+	if outPath := c.String("out"); outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+		defer out.Close()
+	}
+
+	err = writeCollocations(out, matrix.collocations(c.Int("min-count"), c.StringSlice("focus")))
+	if err != nil {
+		color.Red(err.Error())
+	}
+
+	return
+}
+
+func tfidf(c *cli.Context) (err error) {
+	if c.NumFlags() < 3 {
+		color.Red("Missing one or more args: \n\t-freq-key for path to the frequency decryption key file \n\t-data-dir for directory of encrypted data files \n\t-out for path of the vectors")
+		return
+	}
+
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// read freq key
+	freqOuterKey, err := readFrequencyKey(c.String("freq-key"), opener)
+	if err != nil {
+		color.Red("Cannot read freq key: %s", err)
+		return
+	}
+
+	// keyword keys are optional: their hits are extra features
+	var keywordKeys []pks.PrivateKey
+	if keyDirPath := c.String("key-dir"); keyDirPath != "" {
+		keywordKeys, err = readKeywordKeys(keyDirPath, opener)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+	}
+
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	dataDir := c.String("data-dir")
+	decryptOpts, err := corpusDecryptOptions(c, dataDir, format, schema, freqOuterKey)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	var docs []tfidfDoc
+	err = decryptCorpus(dataDir, format, schema, keywordKeys, freqOuterKey, decryptOpts, func(file corpusFile) error {
+		fileDocs, docErr := tfidfDocs(file, c.String("unit"))
+		docs = append(docs, fileDocs...)
+		return docErr
+	})
+	if err != nil {
+		color.Red("Cannot read corpus: %s", err)
+		return
+	}
+
+	if c.String("unit") == "patient" {
+		docs = mergeTFIDFDocs(docs)
+	}
+
+	matrix := buildTFIDF(docs, c.Int("min-df"))
+
+	outPath := c.String("out")
+	out, err := os.Create(outPath)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+	defer out.Close()
+
+	vectorFormat := c.String("vector-format")
+	switch vectorFormat {
+	case "libsvm":
+		err = matrix.writeLibSVM(out)
+	case "mm":
+		err = matrix.writeMatrixMarket(out)
+	case "json":
+		err = matrix.writeJSON(out)
+	default:
+		color.Red("Unknown '-vector-format' %s. Expected one of: libsvm, mm, json", vectorFormat)
+		return
+	}
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	if vectorFormat == "json" {
+		return
+	}
+
+	// the sparse formats need the names of their rows and columns
+	var rowNames []string
+	for _, doc := range matrix.Docs {
+		rowNames = append(rowNames, doc.ID)
+	}
+
+	for suffix, names := range map[string][]string{".rows": rowNames, ".features": matrix.Vocab} {
+		indexFile, createErr := os.Create(outPath + suffix)
+		if createErr != nil {
+			err = createErr
+			color.Red(err.Error())
+			return
+		}
+
+		err = writeIndex(indexFile, names)
+		indexFile.Close()
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+	}
+
+	return
+}
+
+func kwic(c *cli.Context) (err error) {
+	if c.NumFlags() < 2 {
+		color.Red("Missing one or more args: \n\t-key-dir for directory path to functional keys \n\t-data-dir for directory of encrypted data files")
+		return
+	}
+
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// the freq key is optional: without it, context tokens are shown as _
+	var freqOuterKey []byte
+	if freqKeyPath := c.String("freq-key"); freqKeyPath != "" {
+		freqOuterKey, err = readFrequencyKey(freqKeyPath, opener)
+		if err != nil {
+			color.Red("Cannot read freq key: %s", err)
+			return
+		}
+	}
+
+	keywordKeys, err := readKeywordKeys(c.String("key-dir"), opener)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
+
+	dataDir := c.String("data-dir")
+	decryptOpts, err := corpusDecryptOptions(c, dataDir, format, schema, freqOuterKey)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	out := os.Stdout
+	if outPath := c.String("out"); outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+		defer out.Close()
+	}
+
+	var allHits []kwicHit
+	err = decryptCorpus(dataDir, format, schema, keywordKeys, freqOuterKey, decryptOpts, func(file corpusFile) error {
+		hits := kwicHits(file, c.Int("context"))
+		if c.Bool("json") {
+			allHits = append(allHits, hits...)
+			return nil
+		}
+		return writeKWIC(out, path.Base(file.Path), hits)
+	})
+	if err != nil {
+		color.Red("Cannot read corpus: %s", err)
+		return
+	}
+
+	if c.Bool("json") {
+		err = writeKWICJSON(out, allHits)
+		if err != nil {
+			color.Red(err.Error())
+		}
+	}
+
+	return
+}
+
+func cohort(c *cli.Context) (err error) {
 	if c.NumFlags() < 3 {
-		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-data-dir for directory of patient files \n\t-out-dir for the directory of the encrypted patient files")
+		color.Red("Missing one or more args: \n\t-key-dir for directory path to functional keys \n\t-data-dir for directory of encrypted data files \n\t-query for the cohort criteria, e.g. \"Rad:nodule AND Pat:adenocarcinoma\"")
 		return
 	}
 
-	// read master secret file
-	mskPath := c.String("msk")
-	master, err := parseMasterKey(mskPath)
+	query, err := parseCohortQuery(c.String("query"))
 	if err != nil {
-		color.Red(err.Error())
+		color.Red("Cannot parse query: %s", err)
 		return
 	}
 
-	// get and mkdir out path
-	outPath := c.String("out-dir")
-	os.MkdirAll(outPath, 0777)
-
-	// read patient files
-	patientDirPath := c.String("data-dir")
-
-	file, _ := os.Open(patientDirPath)
-	fi, err := file.Stat()
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
 	if err != nil {
-		color.Red("Cannot get file info: %s", err)
+		color.Red(err.Error())
 		return
 	}
 
-	switch mode := fi.Mode(); {
-	case mode.IsDir():
-		files, _ := ioutil.ReadDir(patientDirPath)
-
-		for _, f := range files {
-			in := path.Join(patientDirPath, f.Name())
-			out := path.Join(outPath, f.Name()+".enc")
-
-			err = EncryptAndSavePatientFile(in, out, master)
-			if err != nil {
-				color.Red("Cannot EncryptAndSavePatientFile: %s", err)
-				return
-			}
-		}
-
-	case mode.IsRegular():
-		color.Red("'-data-dir' was given a file. expected a directory.")
-		break
+	keywordKeys, err := readKeywordKeys(c.String("key-dir"), opener)
+	if err != nil {
+		color.Red(err.Error())
+		return
 	}
 
-	return
-}
-
-func decrypt(c *cli.Context) (err error) {
-	if c.NumFlags() < 4 {
-		color.Red("Missing one or more args: \n\t-key-dir for directory path to functional keys \n\t-freq-key for path to the frequency decryption key file \n\t-data-dir for directory of data files \n\t-out-dir for the where to write the partially-decrypted patient files")
+	rangeKeys, err := readRangeKeys(c.String("key-dir"))
+	if err != nil {
+		color.Red("Cannot read range keys: %s", err)
 		return
 	}
 
-	// read freq key
-	freqKeyPath := c.String("freq-key")
-	freqOuterKey, err := ioutil.ReadFile(freqKeyPath)
+	sectionKeys, err := readSectionKeys(c.String("key-dir"))
 	if err != nil {
-		color.Red("Cannot read freq key: %s", err)
+		color.Red("Cannot read section keys: %s", err)
 		return
 	}
 
-	// read all functional keys
-	keyDirPath := c.String("key-dir")
+	// a keyword without a key can never match
+	held := make(map[string]bool)
+	for _, sk := range keywordKeys {
+		held[sk.Keyword] = true
+	}
+	for _, rk := range rangeKeys {
+		held[rk.Label] = true
+	}
+	for _, keyword := range query.keywords() {
+		if !held[keyword] {
+			color.Yellow("No key for keyword '%s': its terms never match", keyword)
+		}
+	}
 
-	file, _ := os.Open(keyDirPath)
-	fi, err := file.Stat()
+	format := c.String("format")
+	schema, err := parseSchema(c.String("schema"))
 	if err != nil {
-		color.Red("Cannot read %s. Error: %s", keyDirPath, err)
+		color.Red("Cannot read schema: %s", err)
 		return
 	}
 
-	var keywordKeys []pks.PrivateKey
-
-	switch mode := fi.Mode(); {
-	case mode.IsDir():
-		files, _ := ioutil.ReadDir(keyDirPath)
-		//read all keys
-		for _, f := range files {
-			fpath := path.Join(keyDirPath, f.Name())
-
-			// try to parse as ibe.private key
-			privateKey, parseErr := parsePrivateKey(fpath)
-			if parseErr == nil {
-				keywordKeys = append(keywordKeys, privateKey)
-			} else {
-				color.Red("Could not parse keyword key %s. Got err: %s", fpath, err)
-			}
+	out := os.Stdout
+	if outPath := c.String("out"); outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			color.Red(err.Error())
+			return
 		}
-	case mode.IsRegular():
-		color.Red("Error: '-key-dir' was given a file. Expected directory.")
-		return
+		defer out.Close()
 	}
 
-	// get and mkdir out path
-	outPath := c.String("out-dir")
-	os.MkdirAll(outPath, 0777)
-
-	// read patient files
-	patientDirPath := c.String("data-dir")
-
-	file, _ = os.Open(patientDirPath)
-	fi, err = file.Stat()
+	writer, err := newCohortWriter(out, query)
 	if err != nil {
-		color.Red("Cannot read %s. Error: %s", patientDirPath, err)
+		color.Red(err.Error())
 		return
 	}
 
-	switch mode := fi.Mode(); {
-	case mode.IsDir():
-		files, _ := ioutil.ReadDir(patientDirPath)
-		for _, f := range files {
+	patients, matches := 0, 0
+	err = decryptCorpus(c.String("data-dir"), format, schema, keywordKeys, nil, DecryptOptions{RangeKeys: rangeKeys, SectionKeys: sectionKeys}, func(file corpusFile) error {
+		if c.Bool("affirmed") {
+			file = affirmedOnly(file)
+		}
 
-			in := path.Join(patientDirPath, f.Name())
-			out := path.Join(outPath, strings.Replace(f.Name(), ".enc", "", 1))
+		// tabular and HL7 files hold many patients
+		for _, patient := range file.patients() {
+			patients += 1
+
+			match, evidence, evalErr := query.evaluate(patient)
+			if evalErr != nil {
+				return evalErr
+			}
+			if !match {
+				continue
+			}
 
-			err = DecryptAndSavePatientFile(in, out, keywordKeys, freqOuterKey)
+			matches += 1
+			err := writer.write(patient, evidence)
 			if err != nil {
-				color.Red("Cannot EncryptAndSavePatientFile: %s", err)
-				return
+				return err
 			}
 		}
-	case mode.IsRegular():
-		color.Red("'-data-dir' was given a file. expected a directory.")
-		break
+		return nil
+	})
+	if err != nil {
+		color.Red("Cannot read corpus: %s", err)
+		return
 	}
 
+	color.Magenta("--- cohort ---")
+	fmt.Fprintf(os.Stderr, "%d of %d patients match\n", matches, patients)
+
 	return
 }
 
-func decryptFreq(c *cli.Context) (err error) {
-	if c.NumFlags() < 2 {
-		color.Red("Missing one of: \n\t-msk for path to master secret key \n\t-c frequency cipher-text \n\t-file multiple frequency cipher-texts in a file")
+func attackSim(c *cli.Context) (err error) {
+	if c.NumFlags() < 4 {
+		color.Red("Missing one or more args: \n\t-plain-dir for directory of plaintext data files \n\t-data-dir for directory of their encryptions \n\t-freq-key for path to the frequency decryption key file \n\t-aux for path to the auxiliary word frequency list")
 		return
 	}
 
-	// read master secret file
-	mskPath := c.String("msk")
-	master, err := parseMasterKey(mskPath)
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
 	if err != nil {
 		color.Red(err.Error())
 		return
 	}
 
-	// try if many
-	filePath := c.String("file")
-	if filePath != "" {
-		fileBytes, fileErr := ioutil.ReadFile(filePath)
-		if fileErr != nil {
-			err = fileErr
-			color.Red(err.Error())
-			return
-		}
-
-		fileCopy := string(fileBytes)
-
-		splitter := func(c rune) bool {
-			return !unicode.IsLetter(c) && !unicode.IsNumber(c)
-		}
-		tokens := strings.FieldsFunc(fileCopy, splitter)
+	// read freq key
+	freqOuterKey, err := readFrequencyKey(c.String("freq-key"), opener)
+	if err != nil {
+		color.Red("Cannot read freq key: %s", err)
+		return
+	}
 
-		for _, t := range tokens {
-			ctxt, decodeErr := base36.DecodeString(t)
-			if decodeErr != nil {
-				continue
-			}
+	schema, err := parseSchema(c.String("schema"))
+	if err != nil {
+		color.Red("Cannot read schema: %s", err)
+		return
+	}
 
-			ptxt, decryptErr := pfs.Uncover(master.FrequencyKey, ctxt)
-			if decryptErr != nil {
-				continue
-			}
+	opts := attackSimOptions{
+		Format: c.String("format"),
+		Schema: schema,
+		Ranges: c.Bool("range"),
+		Seeds:  c.Int("seeds"),
+		Window: c.Int("window"),
+		Rounds: c.Int("rounds"),
+		Top:    c.Int("top"),
+	}
 
-			fileCopy = strings.Replace(fileCopy, t, string(ptxt), -1)
+	if c.Bool("scrub-phi") || c.String("phi-rules") != "" {
+		opts.PHI, err = parsePHIConfig(c.String("phi-rules"))
+		if err != nil {
+			color.Red("Cannot read PHI rules: %s", err)
+			return
 		}
+	}
 
-		fmt.Println(fileCopy)
+	if mergePath := c.String("merge-map"); mergePath != "" {
+		opts.MergeMap, err = readMergeMap(mergePath)
+		if err != nil {
+			color.Red("Cannot read merge map: %s", err)
+			return
+		}
+	}
 
+	aux, err := readAuxFrequencies(c.String("aux"))
+	if err != nil {
+		color.Red("Cannot read auxiliary frequencies: %s", err)
 		return
 	}
 
-	// otherwise just decrypt one
-	// get and mkdir out path
-	ctxt, err := base36.DecodeString(c.String("c"))
+	corpus, err := alignCorpus(c.String("plain-dir"), c.String("data-dir"), freqOuterKey, opts)
 	if err != nil {
-		color.Red(err.Error())
+		color.Red("Cannot read corpus: %s", err)
 		return
 	}
 
-	ptxt, err := pfs.Uncover(master.FrequencyKey, ctxt)
+	if corpus.Skipped > 0 {
+		color.Yellow("Skipped %d files or notes that do not line up with their encryption", corpus.Skipped)
+	}
+
+	// without an auxiliary corpus, the attacker knows the true co-occurrence
+	auxDocs := corpus.WordDocs
+	if auxCorpus := c.String("aux-corpus"); auxCorpus != "" {
+		auxDocs, err = readWordDocs(auxCorpus, opts)
+		if err != nil {
+			color.Red("Cannot read auxiliary corpus: %s", err)
+			return
+		}
+	} else {
+		color.Yellow("No -aux-corpus: the co-occurrence attack knows the plaintext co-occurrence (worst case)")
+	}
+
+	results := runAttacks(corpus, aux, auxDocs, opts)
+
+	out := os.Stdout
+	if outPath := c.String("out"); outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+		defer out.Close()
+	}
+
+	err = writeAttackResults(out, results, c.Bool("json"))
 	if err != nil {
 		color.Red(err.Error())
-		return
 	}
 
-	fmt.Println(string(ptxt))
 	return
 }
 
@@ -388,6 +1686,23 @@ func calcStats(c *cli.Context) (err error) {
 		return
 	}
 
+	// bundle key or identity opening sealed keys
+	opener, err := keyOpener(c)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	// encrypted files need the freq key to tell dummy tokens apart
+	var freqOuterKey []byte
+	if freqKeyPath := c.String("freq-key"); freqKeyPath != "" {
+		freqOuterKey, err = readFrequencyKey(freqKeyPath, opener)
+		if err != nil {
+			color.Red("Cannot read freq key: %s", err)
+			return
+		}
+	}
+
 	totalCount := 0
 	for _, pf := range patientFiles {
 		var patient map[string]interface{}
@@ -403,7 +1718,7 @@ func calcStats(c *cli.Context) (err error) {
 
 		for i := range cardiacNotes {
 			note := cardiacNotes[i].(map[string]interface{})
-			carNoteCount += len(SplitFreeText(note["free_text"].(string)))
+			carNoteCount += noteWordCount(note["free_text"], freqOuterKey)
 		}
 
 		// lno free text
@@ -411,7 +1726,7 @@ func calcStats(c *cli.Context) (err error) {
 		lnoNoteCount := 0
 		for i := range lnoNotes {
 			note := lnoNotes[i].(map[string]interface{})
-			lnoNoteCount += len(SplitFreeText(note["free_text"].(string)))
+			lnoNoteCount += noteWordCount(note["free_text"], freqOuterKey)
 		}
 
 		color.Green("-- stats on %s --", pf)
@@ -457,6 +1772,15 @@ func main() {
 				cli.StringFlag{Name: "out"},
 			},
 		},
+		{
+			Name:    "identity",
+			Aliases: nil,
+			Usage:   "Generate an analyst key: an identity (.id) kept private, and a public key (.pub) that extracted keys are sealed to",
+			Action:  genIdentity,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "out", Usage: "path of the key files, without extension"},
+			},
+		},
 		{
 			Name:    "extract",
 			Aliases: nil,
@@ -470,6 +1794,39 @@ func main() {
 						cli.StringFlag{Name: "words"},
 						cli.StringFlag{Name: "msk"},
 						cli.StringFlag{Name: "out-dir"},
+						cli.StringFlag{Name: "bundle", Usage: "write one key bundle to this path instead of a file per word"},
+						cli.StringFlag{Name: "to", Usage: "public key of the analyst the keys are sealed to, from 'identity'"},
+						cli.StringFlag{Name: "bundle-key", Usage: "bundle key of the analyst receiving the bundle"},
+					},
+				},
+				{
+					Name:   "bundle-key",
+					Usage:  "Generate a key for an analyst to receive key bundles with",
+					Action: genBundleKey,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "out"},
+					},
+				},
+				{
+					Name:   "range",
+					Usage:  "Extract a range key matching numeric tokens in [min, max]",
+					Action: genRangeKey,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "msk"},
+						cli.StringFlag{Name: "out", Usage: "path of the key, ending in .rk to be found in a key dir"},
+						cli.Float64Flag{Name: "min", Usage: "smallest value matched, default 0"},
+						cli.Float64Flag{Name: "max", Usage: "largest value matched, default unbounded"},
+						cli.StringFlag{Name: "label", Usage: "keyword shown for matching tokens, default min..max"},
+					},
+				},
+				{
+					Name:   "section",
+					Usage:  "Extract a section key revealing where an encrypted section is",
+					Action: genSectionKey,
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "msk"},
+						cli.StringFlag{Name: "label", Usage: "section header, e.g. impression or \"hospital course\""},
+						cli.StringFlag{Name: "out", Usage: "path of the key, ending in .sec to be found in a key dir"},
 					},
 				},
 				{
@@ -479,6 +1836,7 @@ func main() {
 					Flags: []cli.Flag{
 						cli.StringFlag{Name: "msk"},
 						cli.StringFlag{Name: "out"},
+						cli.StringFlag{Name: "to", Usage: "public key of the analyst the key is sealed to, from 'identity'"},
 					},
 				},
 			},
@@ -492,6 +1850,23 @@ func main() {
 				cli.StringFlag{Name: "msk"},
 				cli.StringFlag{Name: "data-dir"},
 				cli.StringFlag{Name: "out-dir"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "date-mode", Value: "none", Usage: "de-identify dates: none, shift, bucket. Dates in free text are encrypted shifted, and cannot be unshifted"},
+				cli.StringFlag{Name: "date-bucket", Value: "month", Usage: "bucket size for -date-mode bucket: month, year"},
+				cli.BoolFlag{Name: "date-ore", Usage: "encrypt note dates with order-revealing encryption"},
+				cli.BoolFlag{Name: "scrub-phi", Usage: "replace PHI in free text with placeholders like [NAME] before encrypting"},
+				cli.StringFlag{Name: "phi-rules", Usage: "path to a JSON file of PHI rules and dictionaries (implies -scrub-phi)"},
+				cli.StringFlag{Name: "report", Usage: "path to write a JSON report of the run"},
+				cli.IntFlag{Name: "pad-length", Usage: "pad frequency plaintexts to a multiple of this many bytes, and store them as detached_enc for 'uncover -padded'"},
+				cli.IntFlag{Name: "pad-tokens", Usage: "pad every note with dummy tokens to a multiple of this many tokens"},
+				cli.IntFlag{Name: "smooth", Usage: "split words so each frequency tag occurs at most this many times"},
+				cli.StringFlag{Name: "schedule", Usage: "path to write the encrypted smoothing schedule (required with -smooth)"},
+				cli.BoolFlag{Name: "range", Usage: "also hide numeric tokens for range search with 'extract range' keys"},
+				cli.StringFlag{Name: "prefix-lengths", Usage: "also hide token prefixes of these lengths, e.g. 4,6,8, for keys like cardio*"},
+				cli.StringFlag{Name: "sections", Usage: "also store the section of every token: plain, or encrypted for 'extract section' keys"},
+				cli.BoolFlag{Name: "assertions", Usage: "also seal whether each token is negated, uncertain or historical for its keyword's key holders"},
+				cli.IntFlag{Name: "fuzzy", Usage: fmt.Sprintf("also hide deletion neighborhoods for fuzzy keys like pneumonia~ within this edit distance (1 or 2), at %d or %d ciphertexts per token; prints the leakage", fuzzyEntrySize(1), fuzzyEntrySize(2))},
 			},
 		},
 		{
@@ -501,9 +1876,177 @@ func main() {
 			Action:  decrypt,
 			Flags: []cli.Flag{
 				cli.StringFlag{Name: "key-dir"},
+				cli.StringFlag{Name: "bundle-key", Usage: "bundle key opening -key-dir, if it is a key bundle"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
 				cli.StringFlag{Name: "freq-key"},
 				cli.StringFlag{Name: "data-dir"},
 				cli.StringFlag{Name: "out-dir"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.IntFlag{Name: "k", Usage: "hide frequency tags seen for fewer than k patients or notes"},
+				cli.StringFlag{Name: "k-unit", Value: "patient", Usage: "what k counts: patient (by patient column, field or PID), note"},
+				cli.StringFlag{Name: "k-mode", Value: "collapse", Usage: "rare tags are: collapse (into [rare]), suppress"},
+				cli.StringSliceFlag{Name: "section", Usage: "only search these sections, e.g. impression; repeatable"},
+			},
+		},
+		{
+			Name:   "merge-tags",
+			Usage:  "Write a map merging the salted frequency tags of smoothed words",
+			Action: mergeTags,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "msk"},
+				cli.StringFlag{Name: "schedule"},
+				cli.StringFlag{Name: "out"},
+			},
+		},
+		{
+			Name:   "freq-report",
+			Usage:  "Release differentially private counts of frequency tags",
+			Action: freqReport,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "freq-key"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "ledger", Usage: "path to the privacy budget ledger of this corpus"},
+				cli.StringFlag{Name: "out", Usage: "path of the CSV report, default stdout"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.StringFlag{Name: "mechanism", Value: "laplace", Usage: "noise mechanism: laplace, gaussian (epsilon < 1)"},
+				cli.Float64Flag{Name: "epsilon", Value: 0.1, Usage: "epsilon spent by this report"},
+				cli.Float64Flag{Name: "delta", Value: 1e-6, Usage: "delta spent by this report"},
+				cli.IntFlag{Name: "max-tags", Value: 100, Usage: "most distinct tags counted per patient (by patient column, field or PID)"},
+				cli.Float64Flag{Name: "budget", Value: 1.0, Usage: "total epsilon of a new ledger"},
+				cli.Float64Flag{Name: "delta-budget", Value: 1e-5, Usage: "total delta of a new ledger"},
+				cli.IntFlag{Name: "k", Usage: "hide tags whose noisy patient count is below k"},
+				cli.StringFlag{Name: "k-mode", Value: "collapse", Usage: "rare tags are: collapse (into [rare]), suppress"},
+			},
+		},
+		{
+			Name:   "histogram",
+			Usage:  "Corpus histogram of frequency tags: count, document frequency and per record type counts",
+			Action: histogram,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "freq-key"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.IntFlag{Name: "top", Usage: "only the k most frequent tags"},
+				cli.IntFlag{Name: "k", Usage: "hide frequency tags seen for fewer than k patients or notes"},
+				cli.StringFlag{Name: "k-unit", Value: "patient", Usage: "what k counts: patient (by patient column, field or PID), note"},
+				cli.StringFlag{Name: "k-mode", Value: "collapse", Usage: "rare tags are: collapse (into [rare]), suppress"},
+				cli.StringFlag{Name: "out", Usage: "path of the histogram, default stdout"},
+				cli.BoolFlag{Name: "json", Usage: "write the histogram as JSON instead of CSV"},
+			},
+		},
+		{
+			Name:   "cooccur",
+			Usage:  "Windowed co-occurrence counts and PMI of frequency tags and keyword hits",
+			Action: cooccur,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "freq-key"},
+				cli.StringFlag{Name: "key-dir", Usage: "directory of keyword keys, whose hits stand in for their tags"},
+				cli.StringFlag{Name: "bundle-key", Usage: "bundle key opening -key-dir, if it is a key bundle"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.IntFlag{Name: "window", Value: 5, Usage: "count tokens at most this many tokens apart"},
+				cli.IntFlag{Name: "min-count", Value: 1, Usage: "only pairs seen at least this many times"},
+				cli.StringSliceFlag{Name: "focus", Usage: "only pairs with this keyword or tag (repeatable)"},
+				cli.IntFlag{Name: "k", Usage: "hide frequency tags seen for fewer than k patients or notes"},
+				cli.StringFlag{Name: "k-unit", Value: "patient", Usage: "what k counts: patient (by patient column, field or PID), note"},
+				cli.StringFlag{Name: "k-mode", Value: "collapse", Usage: "rare tags are: collapse (into [rare]), suppress"},
+				cli.StringFlag{Name: "out", Usage: "path of the CSV, default stdout"},
+			},
+		},
+		{
+			Name:   "tfidf",
+			Usage:  "Export notes or patients as sparse TF-IDF vectors of frequency tags and keyword hits",
+			Action: tfidf,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "freq-key"},
+				cli.StringFlag{Name: "key-dir", Usage: "directory of keyword keys, whose hits are extra features"},
+				cli.StringFlag{Name: "bundle-key", Usage: "bundle key opening -key-dir, if it is a key bundle"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "out", Usage: "path of the vectors; libsvm and mm also write <out>.rows and <out>.features"},
+				cli.StringFlag{Name: "vector-format", Value: "libsvm", Usage: "vector format: libsvm, mm (Matrix Market), json"},
+				cli.StringFlag{Name: "unit", Value: "note", Usage: "one vector per: note, patient (by patient column, field or PID)"},
+				cli.IntFlag{Name: "min-df", Value: 1, Usage: "drop features in fewer than this many documents"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.IntFlag{Name: "k", Usage: "hide frequency tags seen for fewer than k patients or notes"},
+				cli.StringFlag{Name: "k-unit", Value: "patient", Usage: "what k counts: patient (by patient column, field or PID), note"},
+				cli.StringFlag{Name: "k-mode", Value: "collapse", Usage: "rare tags are: collapse (into [rare]), suppress"},
+			},
+		},
+		{
+			Name:   "kwic",
+			Usage:  "Keyword-in-context concordance of keyword hits, grouped by file and record type",
+			Action: kwic,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "key-dir"},
+				cli.StringFlag{Name: "bundle-key", Usage: "bundle key opening -key-dir, if it is a key bundle"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "freq-key", Usage: "frequency key, to show context tokens as short tag aliases"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.IntFlag{Name: "context", Value: 5, Usage: "tokens of context on each side of a hit"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.IntFlag{Name: "k", Usage: "hide frequency tags seen for fewer than k patients or notes"},
+				cli.StringFlag{Name: "k-unit", Value: "patient", Usage: "what k counts: patient (by patient column, field or PID), note"},
+				cli.StringFlag{Name: "k-mode", Value: "collapse", Usage: "rare tags are: collapse (into [rare]), suppress"},
+				cli.StringFlag{Name: "out", Usage: "path of the concordance, default stdout"},
+				cli.StringSliceFlag{Name: "section", Usage: "only search these sections, e.g. impression; repeatable"},
+				cli.BoolFlag{Name: "json", Usage: "write the hits as JSON"},
+			},
+		},
+		{
+			Name:   "cohort",
+			Usage:  "List the patients matching keyword criteria across record types",
+			Action: cohort,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "key-dir"},
+				cli.StringFlag{Name: "bundle-key", Usage: "bundle key opening -key-dir, if it is a key bundle"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "query", Usage: "criteria like \"Rad:nodule AND (Pat:adenocarcinoma OR Pat:carcinoma)\", \"Rad:impression:nodule\" or \"Car:stemi THEN Dis:aspirin WITHIN 30\""},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.StringFlag{Name: "out", Usage: "path of the CSV of matching files, default stdout"},
+				cli.BoolFlag{Name: "affirmed", Usage: "ignore keyword hits that are negated, uncertain or historical"},
+			},
+		},
+		{
+			Name:   "attack-sim",
+			Usage:  "Simulate frequency analysis attacks on encrypted data and report recovery rates",
+			Action: attackSim,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "plain-dir", Usage: "directory of the plaintext data files"},
+				cli.StringFlag{Name: "data-dir", Usage: "directory of their encryptions"},
+				cli.StringFlag{Name: "freq-key"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
+				cli.StringFlag{Name: "aux", Usage: "path to a public word frequency list: one word per line, optionally with a count"},
+				cli.StringFlag{Name: "aux-corpus", Usage: "directory of plaintext files the attacker learns co-occurrence from, default the plaintext corpus"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema describing tabular columns"},
+				cli.BoolFlag{Name: "scrub-phi", Usage: "the data was encrypted with -scrub-phi"},
+				cli.BoolFlag{Name: "range", Usage: "the data was encrypted with -range"},
+				cli.StringFlag{Name: "phi-rules", Usage: "the PHI rules the data was encrypted with"},
+				cli.StringFlag{Name: "merge-map", Usage: "path to a tag merge map from 'merge-tags'"},
+				cli.IntFlag{Name: "seeds", Value: 10, Usage: "most frequent rank matches seeding the co-occurrence attack"},
+				cli.IntFlag{Name: "window", Value: 20, Usage: "frequency ranks around a tag the co-occurrence attack considers"},
+				cli.IntFlag{Name: "rounds", Value: 3, Usage: "rounds of the co-occurrence attack"},
+				cli.IntFlag{Name: "top", Value: 5000, Usage: "most frequent tags and words the attacks consider, 0 for all; other tags count as not recovered"},
+				cli.StringFlag{Name: "out", Usage: "path of the report, default stdout"},
+				cli.BoolFlag{Name: "json", Usage: "write the report as JSON"},
 			},
 		},
 		{
@@ -514,6 +2057,30 @@ func main() {
 				cli.StringFlag{Name: "msk"},
 				cli.StringFlag{Name: "c"},
 				cli.StringFlag{Name: "file"},
+				cli.BoolFlag{Name: "padded", Usage: "cipher-texts are detached_enc entries of notes encrypted with -pad-length"},
+			},
+		},
+		{
+			Name:   "uncover-fields",
+			Usage:  "Uncover deterministically encrypted structured fields",
+			Action: uncoverFields,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "msk"},
+				cli.StringFlag{Name: "c"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "out-dir"},
+				cli.StringFlag{Name: "format", Value: "json", Usage: "data file format: json, csv, fhir, hl7"},
+				cli.StringFlag{Name: "schema", Usage: "path to a JSON schema naming the encrypted fields"},
+				cli.BoolFlag{Name: "unshift-dates", Usage: "also reverse the per-patient shift of date fields, but not of dates in free text"},
+			},
+		},
+		{
+			Name:   "date-offset",
+			Usage:  "Print the secret date shift of a patient",
+			Action: printDateOffset,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "msk"},
+				cli.StringFlag{Name: "patient"},
 			},
 		},
 		{
@@ -522,7 +2089,9 @@ func main() {
 			Usage:   "Number of free text words in data files",
 			Action:  calcStats,
 			Flags: []cli.Flag{
-				cli.StringFlag{Name: "patient-dir"},
+				cli.StringFlag{Name: "data-dir"},
+				cli.StringFlag{Name: "freq-key", Usage: "frequency key, to skip dummy tokens in encrypted files"},
+				cli.StringFlag{Name: "identity", Usage: "analyst identity opening sealed keys, from 'identity'"},
 			},
 		},
 	}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/pfs"
+	"github.com/agrinman/alvis/pks"
+)
+
+func testMasterKey(t *testing.T) MasterKey {
+	keyword, err := pks.Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frequency, err := pfs.Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return MasterKey{keyword, frequency}
+}
+
+// tagOf is the tag decrypt writes for an unsalted word.
+func tagOf(master MasterKey, word string) string {
+	tag, err := base36.Encode(pfs.Tag(master.FrequencyKey, []byte(word), 0))
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
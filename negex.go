@@ -0,0 +1,310 @@
+package main
+
+import (
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/pks"
+)
+
+// assertion is the NegEx status of a token: whether the note affirms,
+// negates, doubts or places in the past what the token names.
+type assertion byte
+
+const (
+	assertionAffirmed assertion = iota
+	assertionNegated
+	assertionUncertain
+	assertionHistorical
+)
+
+var assertionNames = []string{"affirmed", "negated", "uncertain", "historical"}
+
+func (a assertion) String() string {
+	if int(a) < len(assertionNames) {
+		return assertionNames[a]
+	}
+	return ""
+}
+
+// negexWindow is how many tokens a trigger reaches, as in NegEx
+const negexWindow = 5
+
+// negexTrigger is a phrase that changes the status of the tokens after it,
+// or before it for post triggers. Pseudo triggers look like triggers but
+// change nothing, like "no change".
+type negexTrigger struct {
+	Phrase string
+	Status assertion
+	Post   bool
+	Pseudo bool
+}
+
+var negexTriggers = []negexTrigger{
+	// pseudo triggers
+	{Phrase: "no increase", Pseudo: true},
+	{Phrase: "no change", Pseudo: true},
+	{Phrase: "no significant change", Pseudo: true},
+	{Phrase: "no further", Pseudo: true},
+	{Phrase: "not only", Pseudo: true},
+	{Phrase: "not necessarily", Pseudo: true},
+	{Phrase: "without difficulty", Pseudo: true},
+	{Phrase: "gram negative", Pseudo: true},
+
+	// negation
+	{Phrase: "no", Status: assertionNegated},
+	{Phrase: "not", Status: assertionNegated},
+	{Phrase: "never", Status: assertionNegated},
+	{Phrase: "without", Status: assertionNegated},
+	{Phrase: "denies", Status: assertionNegated},
+	{Phrase: "denied", Status: assertionNegated},
+	{Phrase: "denying", Status: assertionNegated},
+	{Phrase: "negative for", Status: assertionNegated},
+	{Phrase: "no evidence of", Status: assertionNegated},
+	{Phrase: "no sign of", Status: assertionNegated},
+	{Phrase: "no signs of", Status: assertionNegated},
+	{Phrase: "free of", Status: assertionNegated},
+	{Phrase: "absence of", Status: assertionNegated},
+	{Phrase: "fails to reveal", Status: assertionNegated},
+	{Phrase: "ruled out", Status: assertionNegated},
+	{Phrase: "ruled out", Status: assertionNegated, Post: true},
+	{Phrase: "is ruled out", Status: assertionNegated, Post: true},
+	{Phrase: "was ruled out", Status: assertionNegated, Post: true},
+	{Phrase: "absent", Status: assertionNegated, Post: true},
+	{Phrase: "not seen", Status: assertionNegated, Post: true},
+	{Phrase: "unlikely", Status: assertionNegated, Post: true},
+
+	// uncertainty
+	{Phrase: "possible", Status: assertionUncertain},
+	{Phrase: "possibly", Status: assertionUncertain},
+	{Phrase: "probable", Status: assertionUncertain},
+	{Phrase: "probably", Status: assertionUncertain},
+	{Phrase: "likely", Status: assertionUncertain},
+	{Phrase: "presumed", Status: assertionUncertain},
+	{Phrase: "suspected", Status: assertionUncertain},
+	{Phrase: "suspicious for", Status: assertionUncertain},
+	{Phrase: "suspicion of", Status: assertionUncertain},
+	{Phrase: "questionable", Status: assertionUncertain},
+	{Phrase: "question of", Status: assertionUncertain},
+	{Phrase: "concern for", Status: assertionUncertain},
+	{Phrase: "rule out", Status: assertionUncertain},
+	{Phrase: "r o", Status: assertionUncertain},
+	{Phrase: "may be", Status: assertionUncertain},
+	{Phrase: "cannot exclude", Status: assertionUncertain},
+	{Phrase: "versus", Status: assertionUncertain},
+	{Phrase: "vs", Status: assertionUncertain},
+	{Phrase: "suspected", Status: assertionUncertain, Post: true},
+	{Phrase: "is possible", Status: assertionUncertain, Post: true},
+	{Phrase: "cannot be excluded", Status: assertionUncertain, Post: true},
+	{Phrase: "cannot be ruled out", Status: assertionUncertain, Post: true},
+	{Phrase: "not ruled out", Status: assertionUncertain, Post: true},
+
+	// history
+	{Phrase: "history of", Status: assertionHistorical},
+	{Phrase: "hx of", Status: assertionHistorical},
+	{Phrase: "h o", Status: assertionHistorical},
+	{Phrase: "status post", Status: assertionHistorical},
+	{Phrase: "s p", Status: assertionHistorical},
+	{Phrase: "previous", Status: assertionHistorical},
+	{Phrase: "prior", Status: assertionHistorical},
+	{Phrase: "remote", Status: assertionHistorical},
+	{Phrase: "resolved", Status: assertionHistorical, Post: true},
+	{Phrase: "in the past", Status: assertionHistorical, Post: true},
+	{Phrase: "previously", Status: assertionHistorical, Post: true},
+}
+
+// negexTerminators end the scope of a trigger, as do sentence ends and
+// other triggers.
+var negexTerminators = map[string]bool{
+	"but": true, "however": true, "although": true, "though": true,
+	"except": true, "yet": true, "aside": true, "apart": true, "which": true,
+}
+
+// negexPhrases are the triggers by their tokens, for matching
+var negexPhrases = func() (phrases [][]string) {
+	for _, t := range negexTriggers {
+		phrases = append(phrases, splitWords(t.Phrase, false))
+	}
+	return
+}()
+
+// matchTriggers returns the triggers of the longest phrase starting at
+// tokens[i], and its length in tokens.
+func matchTriggers(tokens []string, i int) (triggers []negexTrigger, length int) {
+	for n, phrase := range negexPhrases {
+		if len(phrase) < length || i+len(phrase) > len(tokens) {
+			continue
+		}
+
+		match := true
+		for j, word := range phrase {
+			if tokens[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		if len(phrase) > length {
+			triggers, length = nil, len(phrase)
+		}
+		triggers = append(triggers, negexTriggers[n])
+	}
+
+	return
+}
+
+//MARK: Detection
+
+// detectAssertions is the status of each of the tokens of a note, found by
+// NegEx over its sentences. If the sentences do not tokenize like the
+// whole note, the note is treated as one sentence.
+func detectAssertions(tokenize tokenizer, text string, tokens []string) (statuses []assertion) {
+	for _, sentence := range splitSentences(text) {
+		sentenceTokens := tokenize.Split(sentence)
+		statuses = append(statuses, negex(sentenceTokens)...)
+	}
+
+	if len(statuses) != len(tokens) {
+		statuses = negex(tokens)
+	}
+
+	return
+}
+
+// splitSentences cuts text at sentence ends, colons and line breaks, but
+// not at decimal points.
+func splitSentences(text string) (sentences []string) {
+	runes := []rune(text)
+
+	start := 0
+	for i, c := range runes {
+		switch c {
+		case '.':
+			if i > 0 && i+1 < len(runes) && isDigit(runes[i-1]) && isDigit(runes[i+1]) {
+				continue
+			}
+		case '!', '?', ';', ':', '\n', '\r':
+		default:
+			continue
+		}
+
+		sentences = append(sentences, string(runes[start:i]))
+		start = i + 1
+	}
+
+	return append(sentences, string(runes[start:]))
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+// negex applies every trigger of one sentence within negexWindow tokens,
+// stopping at terminators and other triggers. Negation outranks
+// uncertainty, which outranks history.
+func negex(tokens []string) []assertion {
+	statuses := make([]assertion, len(tokens))
+
+	isTrigger := make([]bool, len(tokens))
+	for i := range tokens {
+		triggers, _ := matchTriggers(tokens, i)
+		isTrigger[i] = len(triggers) > 0
+	}
+
+	mark := func(j int, status assertion) bool {
+		if isTrigger[j] || negexTerminators[tokens[j]] {
+			return false
+		}
+
+		if statuses[j] == assertionAffirmed || status < statuses[j] {
+			statuses[j] = status
+		}
+		return true
+	}
+
+	for i := 0; i < len(tokens); {
+		triggers, length := matchTriggers(tokens, i)
+		if length == 0 {
+			i += 1
+			continue
+		}
+
+		for _, t := range triggers {
+			if t.Pseudo {
+				break
+			}
+
+			if t.Post {
+				for j := i - 1; j >= 0 && j >= i-negexWindow && mark(j, t.Status); j-- {
+				}
+			} else {
+				for j := i + length; j < len(tokens) && j < i+length+negexWindow && mark(j, t.Status); j++ {
+				}
+			}
+		}
+
+		i += length
+	}
+
+	return statuses
+}
+
+//MARK: Assertion payloads
+
+// assertionKey seals the assertions of a keyword's tokens, so only holders
+// of the keyword's exact key learn them. Prefix and fuzzy key holders do
+// not.
+func assertionKey(sk pks.PrivateKey) []byte {
+	return cryptutil.H(sk.Key, []byte("alvis-assertion"))
+}
+
+// encryptAssertions seals the status of every token under its keyword's
+// assertion key
+func encryptAssertions(master MasterKey, tokens []string, statuses []assertion) (entries []string, err error) {
+	for i, t := range tokens {
+		ctxt, encErr := cryptutil.AESEncrypt(assertionKey(master.KeywordKey.Extract(t)), []byte{byte(statuses[i])})
+		if encErr != nil {
+			return nil, encErr
+		}
+
+		entry, encodeErr := base36.EncodeFixed(ctxt)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// openAssertion is the status of a token sealed for a keyword key, or ""
+// if the payload has none.
+func openAssertion(sk pks.PrivateKey, entries []string, i int) string {
+	if i >= len(entries) {
+		return ""
+	}
+
+	ctxt, err := base36.DecodeString(entries[i])
+	if err != nil {
+		return ""
+	}
+
+	status, err := cryptutil.AESDecrypt(assertionKey(sk), ctxt)
+	if err != nil || len(status) != 1 {
+		return ""
+	}
+
+	return assertion(status[0]).String()
+}
+
+// assertedKeyword marks a keyword hit with its status, unless affirmed or
+// unknown, like pneumonia[negated]
+func assertedKeyword(keyword string, status string) string {
+	if status == "" || status == assertionAffirmed.String() {
+		return keyword
+	}
+
+	return keyword + "[" + status + "]"
+}
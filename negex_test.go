@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+func TestDetectAssertions(t *testing.T) {
+	cases := []struct {
+		Text     string
+		Word     string
+		Expected assertion
+	}{
+		{"no pneumonia but has effusion", "pneumonia", assertionNegated},
+		{"no pneumonia but has effusion", "effusion", assertionAffirmed},
+		{"possible pneumonia. stemi", "pneumonia", assertionUncertain},
+		{"possible pneumonia. stemi", "stemi", assertionAffirmed},
+		{"history of stemi. chest pain", "stemi", assertionHistorical},
+		{"pneumonia was ruled out; no change in nodule", "pneumonia", assertionNegated},
+		{"pneumonia was ruled out; no change in nodule", "nodule", assertionAffirmed},
+	}
+
+	for _, c := range cases {
+		tokens := SplitFreeText(c.Text)
+		statuses := detectAssertions(tokenizer{}, c.Text, tokens)
+
+		for i, token := range tokens {
+			if token == c.Word && statuses[i] != c.Expected {
+				t.Errorf("%s in %q is %s. Expected %s.", c.Word, c.Text, statuses[i], c.Expected)
+			}
+		}
+	}
+}
+
+func TestAssertionsRoundTrip(t *testing.T) {
+	master := testMasterKey(t)
+
+	payload, err := encryptFreeText(master, EncryptOptions{Assertions: true}, noteContext{}, "no pneumonia. possible stemi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []pks.PrivateKey{master.KeywordKey.Extract("pneumonia"), master.KeywordKey.Extract("stemi")}
+	_, hits := decryptFreeText(payload, keys, nil, DecryptOptions{})
+	if len(hits) != 2 || hits[0] != "pneumonia[negated]" || hits[1] != "stemi[uncertain]" {
+		t.Fatalf("Unexpected hits: %v", hits)
+	}
+}
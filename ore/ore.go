@@ -0,0 +1,55 @@
+package ore
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+// Bits is the size of the plaintext domain
+const Bits = 32
+
+// Encrypt is the order-revealing encryption of Chenette, Lewi, Weis and Wu:
+// the i-th trit of the ciphertext is the i-th bit of x plus a PRF of the
+// bits before it, mod 3. Anyone can compare two ciphertexts, which reveals
+// their order and the index of the first bit where they differ.
+func Encrypt(key []byte, x uint32) (ctxt []byte) {
+	ctxt = make([]byte, Bits)
+	prefix := make([]byte, 5)
+
+	for i := 0; i < Bits; i++ {
+		bit := byte((x >> uint(Bits-1-i)) & 1)
+
+		// the PRF input is the index and the bits above it
+		prefix[0] = byte(i)
+		binary.BigEndian.PutUint32(prefix[1:], uint32(uint64(x)>>uint(Bits-i)))
+
+		f := binary.BigEndian.Uint64(cryptutil.H(prefix, key)) % 3
+		ctxt[i] = byte((uint64(bit) + f) % 3)
+	}
+
+	return
+}
+
+// Compare returns -1, 0 or 1 as the plaintext of a is less than, equal to
+// or greater than the plaintext of b.
+func Compare(a []byte, b []byte) (result int, err error) {
+	if len(a) != Bits || len(b) != Bits {
+		err = errors.New("Invalid ORE ciphertext length")
+		return
+	}
+
+	for i := 0; i < Bits; i++ {
+		if a[i] == b[i] {
+			continue
+		}
+
+		if a[i] == (b[i]+1)%3 {
+			return 1, nil
+		}
+		return -1, nil
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,46 @@
+package ore
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+func TestCompare(t *testing.T) {
+	key, err := cryptutil.RandKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []uint32{0, 1, 2, 17, 1 << 31, 1<<32 - 1}
+	for i := 0; i < 100; i++ {
+		values = append(values, rand.Uint32())
+	}
+
+	for _, x := range values {
+		for _, y := range values {
+			expected := 0
+			if x < y {
+				expected = -1
+			} else if x > y {
+				expected = 1
+			}
+
+			result, err := Compare(Encrypt(key, x), Encrypt(key, y))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != expected {
+				t.Errorf("Compare(%d, %d) = %d. Expected %d.", x, y, result, expected)
+			}
+		}
+	}
+}
+
+func TestCompareLength(t *testing.T) {
+	_, err := Compare([]byte{1}, []byte{1})
+	if err == nil {
+		t.Error("Expected an error for a short ciphertext")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"unicode"
@@ -12,6 +13,7 @@ import (
 	"github.com/agrinman/alvis/base36"
 	"github.com/agrinman/alvis/pfs"
 	"github.com/agrinman/alvis/pks"
+	"github.com/agrinman/alvis/prs"
 
 	"github.com/fatih/color"
 )
@@ -20,48 +22,119 @@ var (
 	recordTypes = []string{"Car", "Lno", "Dis", "Mic", "Opn", "Pat", "Rad"}
 )
 
+// EncryptOptions are the settings shared by every data file format
+type EncryptOptions struct {
+	Schema Schema
+
+	// DateMode is "shift", "bucket" or empty to leave dates alone
+	DateMode   string
+	DateBucket string
+
+	// DateORE encrypts note dates so that only their order is revealed
+	DateORE bool
+
+	// PHI scrubs notes before tokenization when set
+	PHI    *phiScrubber
+	Report *runReport
+
+	// PadLength pads frequency plaintexts to a multiple of this many bytes,
+	// and stores their detached ciphertexts for 'uncover -padded'
+	PadLength int
+
+	// TokenBucket pads every note with dummies to a multiple of this many tokens
+	TokenBucket int
+
+	// Smoothing spreads frequent words over several frequency tags
+	Smoothing smoothingSchedule
+
+	// Ranges hides numeric tokens for range search as well
+	Ranges bool
+
+	// PrefixLengths are the lengths of the prefixes hidden for prefix search
+	PrefixLengths []int
+
+	// Sections stores the section of every token, "plain" or "encrypted"
+	Sections string
+
+	// FuzzyDistance hides deletion neighborhoods for fuzzy search within
+	// this edit distance, if positive
+	FuzzyDistance int
+
+	// Assertions seals the NegEx status of every token for its keyword's
+	// key holders
+	Assertions bool
+}
+
+// DecryptOptions are the settings shared by every data file format
+type DecryptOptions struct {
+	// MergeMap collapses salted frequency tags into one tag per word
+	MergeMap map[string]string
+
+	// RareTags are collapsed into a single rare tag, or dropped if SuppressRare
+	RareTags     map[string]bool
+	SuppressRare bool
+
+	// RangeKeys reveal numeric tokens in their range, by the key's label
+	RangeKeys []prs.RangeKey
+
+	// SectionKeys reveal encrypted section labels. Sections restricts
+	// keyword hits to tokens in these sections, if any are given.
+	SectionKeys []pks.PrivateKey
+	Sections    []string
+}
+
+// noteContext identifies where a note being encrypted came from
+type noteContext struct {
+	File    string
+	Patient string
+	Record  string
+}
+
 //MARK: Encryption/Decryption
-func EncryptAndSavePatientFile(inpath string, outpath string, master MasterKey) (err error) {
+func EncryptAndSavePatientFile(inpath string, outpath string, master MasterKey, opts EncryptOptions) (err error) {
 	patient, err := readPatientFile(inpath)
-	encryptedPatient := ApplyCryptorToPatient(patient, func(freeText interface{}) interface{} {
+	if err != nil {
+		return
+	}
 
-		tokens := SplitFreeText(freeText.(string))
-		numTokens := len(tokens)
+	note := noteContext{File: inpath, Patient: documentPatientID(patient, opts.Schema.PatientField, inpath)}
 
-		encryptedKeywordFETokens := make([]string, numTokens)
-		for i, t := range tokens {
-			ctxtBytes, errEnc := master.KeywordKey.Hide(t)
-			if errEnc != nil {
-				color.Red("Found error while encrypting/serializing keyword: ", errEnc)
-			}
+	err = ApplyCryptorToFields(patient, opts.Schema.DateFields, newDateShifter(master, opts, note.Patient).Field)
+	if err != nil {
+		return
+	}
 
-			var errEncode error
-			encryptedKeywordFETokens[i], errEncode = base36.Encode(ctxtBytes)
-			if errEncode != nil {
-				color.Red("Found error while encoding keyword: ", errEncode)
+	if opts.DateORE {
+		var datePaths []string
+		for _, record := range recordTypes {
+			for _, f := range opts.Schema.noteDateFields("json") {
+				datePaths = append(datePaths, record+"."+f)
 			}
+		}
 
+		err = ApplyCryptorToFields(patient, datePaths, oreDates(master))
+		if err != nil {
+			return
 		}
+	}
 
-		encryptedFreqFETokens := make([]string, len(tokens))
+	fieldKey := master.FieldKey()
+	err = ApplyCryptorToFields(patient, opts.Schema.DetFields, func(value string) (string, error) {
+		return encryptField(fieldKey, value)
+	})
+	if err != nil {
+		return
+	}
 
-		for i := range tokens {
-			res, resErr := pfs.Disguise(master.FrequencyKey, []byte(tokens[i]))
-			if resErr != nil {
-				return resErr
-			}
-			var errEncode error
-			encryptedFreqFETokens[i], errEncode = base36.Encode(res.Hidden)
-			if errEncode != nil {
-				color.Red("Found error while encoding keyword: ", errEncode)
-			}
+	encryptedPatient := ApplyCryptorToPatient(patient, func(record string, freeText interface{}) interface{} {
+		recordNote := note
+		recordNote.Record = record
 
+		resultMap, encErr := encryptFreeText(master, opts, recordNote, freeText.(string))
+		if encErr != nil {
+			return encErr
 		}
 
-		resultMap := make(map[string]interface{})
-		resultMap["keyword_enc"] = encryptedKeywordFETokens
-		resultMap["frequency_enc"] = encryptedFreqFETokens
-
 		return resultMap
 	})
 
@@ -69,89 +142,439 @@ func EncryptAndSavePatientFile(inpath string, outpath string, master MasterKey)
 	return
 }
 
-func DecryptAndSavePatientFile(inpath string, outpath string, keywordKeys []pks.PrivateKey, freqOuter []byte) (err error) {
+func DecryptAndSavePatientFile(inpath string, outpath string, keywordKeys []pks.PrivateKey, freqOuter []byte, opts DecryptOptions) (err error) {
 
 	patient, err := readPatientFile(inpath)
 
 	stats := make(map[string]int)
 	statsMutex := &sync.Mutex{}
-	decryptedPatient := ApplyCryptorToPatient(patient, func(encryptedMap interface{}) interface{} {
+	decryptedPatient := ApplyCryptorToPatient(patient, func(record string, encryptedMap interface{}) interface{} {
 
 		inMap, ok := encryptedMap.(map[string]interface{})
 		if !ok {
 			fmt.Println("Unexpected type: ", encryptedMap)
 		}
 
-		encryptedKeywordFETokens := inMap["keyword_enc"].([]interface{})
-		encryptedFreqFETokens := inMap["frequency_enc"].([]interface{})
+		text, hits := decryptFreeText(inMap, keywordKeys, freqOuter, opts)
 
-		if len(encryptedFreqFETokens) != len(encryptedKeywordFETokens) {
-			color.Red("Fatal: Keyword / Frequency encrypted token lists have different lengths.")
-			os.Exit(2)
+		statsMutex.Lock()
+		for _, w := range hits {
+			stats[w] += 1
 		}
+		statsMutex.Unlock()
 
-		//numTokens := len(encryptedFreqFETokens)
+		return text
+	})
 
-		decryptedTokens := make([]string, len(encryptedFreqFETokens))
+	color.Green("-- stats on %s --", inpath)
+	printStats(stats)
 
-		for i, t := range encryptedFreqFETokens {
-			tbytes, errDecode := base36.DecodeString(t.(string))
-			if errDecode != nil {
-				color.Red("Cannot decode (1): %s. Error: %s", t.(string), errDecode)
-				continue
+	err = writePatient(decryptedPatient, outpath)
+	return
+}
+
+// encryptFreeText tokenizes a note and hides every token under both the
+// keyword and frequency keys. The result is the note's encrypted payload.
+func encryptFreeText(master MasterKey, opts EncryptOptions, note noteContext, freeText string) (resultMap map[string]interface{}, err error) {
+	freeText, redactions := opts.PHI.Scrub(freeText)
+	opts.Report.addNote(note.File, redactions)
+
+	freeText = newDateShifter(master, opts, note.Patient).Text(freeText)
+
+	tokenize := opts.tokenizer()
+	tokens := tokenize.Split(freeText)
+	numTokens := len(tokens)
+
+	encryptedKeywordFETokens := make([]string, numTokens)
+	for i, t := range tokens {
+		ctxtBytes, errEnc := master.KeywordKey.Hide(t)
+		if errEnc != nil {
+			color.Red("Found error while encrypting/serializing keyword: %s", errEnc)
+		}
+
+		var errEncode error
+		encryptedKeywordFETokens[i], errEncode = base36.EncodeFixed(ctxtBytes)
+		if errEncode != nil {
+			color.Red("Found error while encoding keyword: %s", errEncode)
+		}
+
+	}
+
+	encryptedFreqFETokens := make([]string, len(tokens))
+	var detachedTokens []string
+	if opts.PadLength > 0 {
+		detachedTokens = make([]string, len(tokens))
+	}
+
+	for i := range tokens {
+		salt, resErr := opts.Smoothing.salt(tokens[i])
+		if resErr != nil {
+			err = resErr
+			return
+		}
+
+		res, resErr := pfs.DisguiseSalted(master.FrequencyKey, []byte(tokens[i]), salt, opts.PadLength)
+		if resErr != nil {
+			err = resErr
+			return
+		}
+		var errEncode error
+		encryptedFreqFETokens[i], errEncode = base36.EncodeFixed(res.Hidden)
+		if errEncode != nil {
+			color.Red("Found error while encoding keyword: %s", errEncode)
+		}
+
+		if detachedTokens != nil {
+			detachedTokens[i], err = base36.EncodeFixed(res.Detached)
+			if err != nil {
+				return
 			}
+		}
 
-			decryptedToken, errDecr := pfs.Recognize(freqOuter, tbytes)
-			if errDecr != nil {
-				color.Red("Cannot decrypt bytes: %d", tbytes)
-				continue
+	}
+
+	var mask []bool
+	if opts.TokenBucket > 0 {
+		mask, err = dummyMask(numTokens, opts.TokenBucket)
+		if err != nil {
+			return
+		}
+
+		encryptedKeywordFETokens, err = spliceDummies(encryptedKeywordFETokens, mask, dummyKeywordToken)
+		if err != nil {
+			return
+		}
+
+		encryptedFreqFETokens, err = spliceDummies(encryptedFreqFETokens, mask, dummyFrequencyToken(master))
+		if err != nil {
+			return
+		}
+
+		if detachedTokens != nil {
+			detachedTokens, err = spliceDummies(detachedTokens, mask, dummyDetachedToken(tokens, opts.PadLength))
+			if err != nil {
+				return
 			}
+		}
+	}
 
-			var errEncode error
-			decryptedTokens[i], errEncode = base36.Encode(decryptedToken)
-			if errEncode != nil {
-				color.Red("Found error while encoding keyword: ", errEncode)
+	resultMap = make(map[string]interface{})
+	resultMap["keyword_enc"] = encryptedKeywordFETokens
+	resultMap["frequency_enc"] = encryptedFreqFETokens
+	if detachedTokens != nil {
+		resultMap["detached_enc"] = detachedTokens
+	}
+
+	if len(opts.PrefixLengths) > 0 {
+		prefixEntries, prefixErr := encryptPrefixes(master, tokens, opts.PrefixLengths)
+		if prefixErr != nil {
+			err = prefixErr
+			return
+		}
+
+		if mask != nil {
+			prefixEntries, err = spliceDummies(prefixEntries, mask, dummyPrefixToken(opts.PrefixLengths))
+			if err != nil {
+				return
+			}
+		}
+
+		resultMap["prefix_enc"] = prefixEntries
+	}
+
+	if opts.FuzzyDistance > 0 {
+		fuzzyEntries, fuzzyErr := encryptFuzzy(master, tokens, opts.FuzzyDistance)
+		if fuzzyErr != nil {
+			err = fuzzyErr
+			return
+		}
+
+		if mask != nil {
+			fuzzyEntries, err = spliceDummies(fuzzyEntries, mask, dummyFuzzyToken(opts.FuzzyDistance))
+			if err != nil {
+				return
+			}
+		}
+
+		resultMap["fuzzy_enc"] = fuzzyEntries
+	}
+
+	switch opts.Sections {
+	case sectionsPlain:
+		labels := detectSections(tokenize, freeText, tokens, opts.Schema.sectionHeaders(note.Record))
+		if entries := plainSections(labels, mask); len(entries) > 0 {
+			resultMap["sections"] = entries
+		}
+	case sectionsEncrypted:
+		sectionEntries, sectionErr := encryptSections(master, detectSections(tokenize, freeText, tokens, opts.Schema.sectionHeaders(note.Record)))
+		if sectionErr != nil {
+			err = sectionErr
+			return
+		}
+
+		if mask != nil {
+			sectionEntries, err = spliceDummies(sectionEntries, mask, dummyKeywordToken)
+			if err != nil {
+				return
 			}
+		}
 
+		resultMap["section_enc"] = sectionEntries
+	}
+
+	if opts.Assertions {
+		assertionEntries, assertionErr := encryptAssertions(master, tokens, detectAssertions(tokenize, freeText, tokens))
+		if assertionErr != nil {
+			err = assertionErr
+			return
 		}
 
-		// next do keyword fe decryptions
-		for i, ctxtString := range encryptedKeywordFETokens {
-			ctxt, errDecode := base36.DecodeString(ctxtString.(string))
-			if errDecode != nil {
-				color.Red("Cannot decode cipher text: %s. Error: ", ctxt, errDecode)
+		if mask != nil {
+			assertionEntries, err = spliceDummies(assertionEntries, mask, dummyKeywordToken)
+			if err != nil {
+				return
 			}
-			for _, sk := range keywordKeys {
-				if sk.Check(ctxt) {
-					statsMutex.Lock()
-					stats[sk.Keyword] += 1
-					statsMutex.Unlock()
-
-					//color.Magenta("Decrypted keyword successfully: %s", sk.Keyword)
-					decryptedTokens[i] = sk.Keyword
+		}
+
+		resultMap["assertion_enc"] = assertionEntries
+	}
+
+	if opts.Ranges {
+		rangeEntries, rangeErr := encryptRanges(master, tokens, mask)
+		if rangeErr != nil {
+			err = rangeErr
+			return
+		}
+
+		if len(rangeEntries) > 0 {
+			resultMap["range_enc"] = rangeEntries
+		}
+	}
+
+	return
+}
+
+// decryptFreeText recognizes the frequency tags of an encrypted payload and
+// reveals any tokens matching the keyword keys. It returns the space-joined
+// note along with the keyword of every hit, marked with its status if not
+// affirmed. Dummy tokens are dropped.
+func decryptFreeText(inMap map[string]interface{}, keywordKeys []pks.PrivateKey, freqOuter []byte, opts DecryptOptions) (text string, hits []string) {
+	note := decryptNote(inMap, keywordKeys, freqOuter, opts)
+
+	decryptedTokens := make([]string, len(note.Tags))
+	for i := range note.Tags {
+		decryptedTokens[i] = note.Tags[i]
+		if note.Keywords[i] != "" {
+			hit := assertedKeyword(note.Keywords[i], note.Assertions[i])
+			hits = append(hits, hit)
+			decryptedTokens[i] = hit
+		}
+	}
+
+	text = strings.Join(decryptedTokens, " ")
+	return
+}
+
+// decryptedNote is the token by token decryption of a payload: the
+// recognized frequency tag of each token, the keyword it matched if any,
+// the NegEx status of exact keyword hits if sealed, and the section of
+// each token if known.
+type decryptedNote struct {
+	Tags       []string
+	Keywords   []string
+	Assertions []string
+	Sections   []string
+}
+
+// decryptNote recognizes and checks every token of a payload, dropping
+// dummies. Without a freq key, tags are left empty and dummies kept, since
+// they never match a keyword.
+func decryptNote(inMap map[string]interface{}, keywordKeys []pks.PrivateKey, freqOuter []byte, opts DecryptOptions) (note decryptedNote) {
+	encryptedKeywordFETokens := payloadTokens(inMap, "keyword_enc")
+	encryptedFreqFETokens := payloadTokens(inMap, "frequency_enc")
+	encryptedPrefixes := payloadTokens(inMap, "prefix_enc")
+	encryptedFuzzy := payloadTokens(inMap, "fuzzy_enc")
+	encryptedAssertions := payloadTokens(inMap, "assertion_enc")
+
+	if len(encryptedFreqFETokens) != len(encryptedKeywordFETokens) {
+		color.Red("Fatal: Keyword / Frequency encrypted token lists have different lengths.")
+		os.Exit(2)
+	}
+
+	decryptedTokens := make([]string, len(encryptedFreqFETokens))
+	keywords := make([]string, len(encryptedFreqFETokens))
+	assertions := make([]string, len(encryptedFreqFETokens))
+	dummies := make([]bool, len(encryptedFreqFETokens))
+
+	for i, t := range encryptedFreqFETokens {
+		if freqOuter == nil {
+			break
+		}
+
+		tbytes, errDecode := base36.DecodeString(t)
+		if errDecode != nil {
+			color.Red("Cannot decode (1): %s. Error: %s", t, errDecode)
+			continue
+		}
+
+		decryptedToken, errDecr := pfs.Recognize(freqOuter, tbytes)
+		if errDecr != nil {
+			color.Red("Cannot decrypt bytes: %d", tbytes)
+			continue
+		}
+
+		if pfs.IsDummy(freqOuter, decryptedToken) {
+			dummies[i] = true
+			continue
+		}
+
+		var errEncode error
+		decryptedTokens[i], errEncode = base36.Encode(decryptedToken)
+		if errEncode != nil {
+			color.Red("Found error while encoding keyword: %s", errEncode)
+		}
+		decryptedTokens[i] = mergeTag(opts.MergeMap, decryptedTokens[i])
+
+	}
+
+	// next do keyword fe decryptions
+	for i, ctxtString := range encryptedKeywordFETokens {
+		if dummies[i] || len(keywordKeys) == 0 {
+			continue
+		}
+
+		ctxt, errDecode := base36.DecodeString(ctxtString)
+		if errDecode != nil {
+			color.Red("Cannot decode cipher text: %s. Error: %s", ctxtString, errDecode)
+		}
+		for _, sk := range keywordKeys {
+			if isPrefixKey(sk) {
+				if i < len(encryptedPrefixes) && checkEntry(sk, encryptedPrefixes[i]) {
+					keywords[i] = sk.Keyword
+				}
+			} else if isFuzzyKey(sk) {
+				if keywords[i] == "" && i < len(encryptedFuzzy) && checkEntry(sk, encryptedFuzzy[i]) {
+					keywords[i] = sk.Keyword
 				}
+			} else if sk.Check(ctxt) {
+				keywords[i] = sk.Keyword
+				assertions[i] = openAssertion(sk, encryptedAssertions, i)
 			}
 		}
+	}
 
-		return strings.Join(decryptedTokens, " ")
-	})
+	// numbers in the range of a range key are revealed by its label
+	for position, label := range rangeHits(inMap, opts.RangeKeys) {
+		if position < len(keywords) && !dummies[position] && keywords[position] == "" {
+			keywords[position] = label
+		}
+	}
 
-	color.Green("-- stats on %s --", inpath)
-	printStats(stats)
+	sections := noteSections(inMap, len(decryptedTokens), opts.SectionKeys)
+	for i := range decryptedTokens {
+		if !inSections(sections[i], opts.Sections) {
+			keywords[i], assertions[i] = "", ""
+		}
+
+		if !dummies[i] {
+			note.Tags = append(note.Tags, decryptedTokens[i])
+			note.Keywords = append(note.Keywords, keywords[i])
+			note.Assertions = append(note.Assertions, assertions[i])
+			note.Sections = append(note.Sections, sections[i])
+		}
+	}
+
+	if opts.RareTags != nil {
+		note = applyRareTags(note, opts.RareTags, opts.SuppressRare)
+	}
+
+	return
+}
+
+// noteWordCount counts the words of a plaintext note, or the tokens of an
+// encrypted one. Dummy tokens are only skipped when the freq key is given.
+func noteWordCount(freeText interface{}, freqOuter []byte) (count int) {
+	switch t := freeText.(type) {
+	case string:
+		count = len(SplitFreeText(t))
+	case map[string]interface{}:
+		for _, tok := range payloadTokens(t, "frequency_enc") {
+			if freqOuter != nil && isDummyToken(freqOuter, tok) {
+				continue
+			}
+			count += 1
+		}
+	}
 
-	err = writePatient(decryptedPatient, outpath)
+	return
+}
+
+// payloadTokens returns the named token list of an encrypted payload, which
+// is a []string when freshly encrypted and a []interface{} once it has been
+// through JSON.
+func payloadTokens(payload map[string]interface{}, name string) (tokens []string) {
+	switch v := payload[name].(type) {
+	case []string:
+		tokens = v
+	case []interface{}:
+		tokens = make([]string, len(v))
+		for i := range v {
+			tokens[i], _ = v[i].(string)
+		}
+	}
 	return
 }
 
 //MARK: Free text helpers
-func SplitFreeText(text string) []string {
+
+// tokenizer splits notes as SplitFreeText does, unless an encryption
+// option needs more: with Decimals, numbers like 34.9 are one token, and
+// with Placeholders, those left by the PHI scrubber, like [NAME]. Only
+// those options change tokens, so older corpora and keys keep matching.
+type tokenizer struct {
+	Decimals     bool
+	Placeholders bool
+}
+
+// SplitFreeText tokenizes a note into lower case words
+func SplitFreeText(text string) (tokens []string) {
+	return tokenizer{}.Split(text)
+}
+
+func (tk tokenizer) Split(text string) (tokens []string) {
+	if !tk.Placeholders {
+		return splitWords(text, tk.Decimals)
+	}
+
+	start := 0
+	for _, span := range placeholderPattern.FindAllStringIndex(text, -1) {
+		tokens = append(tokens, splitWords(text[start:span[0]], tk.Decimals)...)
+		tokens = append(tokens, text[span[0]:span[1]])
+		start = span[1]
+	}
+
+	return append(tokens, splitWords(text[start:], tk.Decimals)...)
+}
+
+// tokenizer splits notes as encrypt will with these options
+func (opts EncryptOptions) tokenizer() tokenizer {
+	return tokenizer{Decimals: opts.Ranges, Placeholders: opts.PHI != nil}
+}
+
+func splitWords(text string, decimals bool) []string {
 	// cleanup
 	filteredText := strings.Replace(text, "\\r", " ", -1)
 	filteredText = strings.Replace(filteredText, "\\n", " ", -1)
 	filteredText = strings.ToLower(filteredText)
 
-	// split
-	splitter := func(c rune) bool {
+	// split, keeping the decimal point of numbers like 34.9 if asked
+	runes := []rune(filteredText)
+	splitter := func(i int) bool {
+		c := runes[i]
+		if decimals && c == '.' && i > 0 && i+1 < len(runes) {
+			return !unicode.IsDigit(runes[i-1]) || !unicode.IsDigit(runes[i+1])
+		}
+
 		return !unicode.IsLetter(c) &&
 			!unicode.IsNumber(c) &&
 			c != '_' &&
@@ -160,11 +583,27 @@ func SplitFreeText(text string) []string {
 			c != '%'
 	}
 
-	return strings.FieldsFunc(filteredText, splitter)
+	var tokens []string
+	start := -1
+	for i := range runes {
+		if splitter(i) {
+			if start >= 0 {
+				tokens = append(tokens, string(runes[start:i]))
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, string(runes[start:]))
+	}
+
+	return tokens
 }
 
 // parse helper
-func ApplyCryptorToPatient(patient map[string]interface{}, cryptor func(interface{}) interface{}) map[string]interface{} {
+func ApplyCryptorToPatient(patient map[string]interface{}, cryptor func(string, interface{}) interface{}) map[string]interface{} {
 
 	var wg sync.WaitGroup
 	for _, record := range recordTypes {
@@ -175,11 +614,11 @@ func ApplyCryptorToPatient(patient map[string]interface{}, cryptor func(interfac
 
 		for i := range notes {
 			note := notes[i].(map[string]interface{})
-			go func(w *sync.WaitGroup, i int, note map[string]interface{}) {
-				note["free_text"] = cryptor(note["free_text"])
+			go func(w *sync.WaitGroup, record string, i int, note map[string]interface{}) {
+				note["free_text"] = cryptor(record, note["free_text"])
 				newNote[i] = note
 				w.Done()
-			}(&wg, i, note)
+			}(&wg, record, i, note)
 		}
 
 		patient[record] = newNote
@@ -189,6 +628,26 @@ func ApplyCryptorToPatient(patient map[string]interface{}, cryptor func(interfac
 	return patient
 }
 
+//MARK: patient ids
+
+// documentPatientID identifies the patient of a JSON document by the schema's
+// patient field, falling back to the file name.
+func documentPatientID(doc map[string]interface{}, patientField string, filepath string) string {
+	if patientField != "" {
+		if id, ok := lookupField(doc, strings.Split(patientField, ".")); ok {
+			return id
+		}
+	}
+
+	return defaultPatientID(filepath)
+}
+
+// defaultPatientID is the file name up to its first extension, so that
+// patient_1.json, patient_1.json.enc and their decryptions all agree.
+func defaultPatientID(filepath string) string {
+	return strings.SplitN(path.Base(filepath), ".", 2)[0]
+}
+
 //MARK: patient io
 func readPatientFile(filepath string) (patient map[string]interface{}, err error) {
 	data, _ := ioutil.ReadFile(filepath)
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/pfs"
+)
+
+func TestPaddedDetachedRoundTrip(t *testing.T) {
+	master := testMasterKey(t)
+
+	tokens := []string{"mi", "and", "cardiomyopathy"}
+	payload, err := encryptFreeText(master, EncryptOptions{PadLength: 16, TokenBucket: 8}, noteContext{}, "MI and cardiomyopathy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detached := payload["detached_enc"].([]string)
+	if len(detached) != 8 {
+		t.Fatalf("Expected 8 detached entries, got %d", len(detached))
+	}
+
+	var uncovered []string
+	for _, entry := range detached {
+		if len(entry) != len(detached[0]) {
+			t.Fatalf("Detached entries differ in length: %s, %s", entry, detached[0])
+		}
+
+		ctxt, err := base36.DecodeString(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// dummies uncover to nothing
+		ptxt, err := pfs.UncoverPadded(master.FrequencyKey, ctxt)
+		if err == nil {
+			uncovered = append(uncovered, string(ptxt))
+		}
+	}
+
+	if len(uncovered) != len(tokens) {
+		t.Fatalf("Uncovered %v, expected %v", uncovered, tokens)
+	}
+	for i := range tokens {
+		if uncovered[i] != tokens[i] {
+			t.Fatalf("Uncovered %v, expected %v", uncovered, tokens)
+		}
+	}
+}
+
+func TestDummyDetachedLengths(t *testing.T) {
+	master := testMasterKey(t)
+
+	// "cardiomyopathy" spans two buckets of 8, the other words one
+	realLengths := map[int]bool{}
+	dummyLengths := map[int]bool{}
+	for i := 0; i < 10; i++ {
+		payload, err := encryptFreeText(master, EncryptOptions{PadLength: 8, TokenBucket: 16}, noteContext{}, "MI and cardiomyopathy")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, entry := range payload["detached_enc"].([]string) {
+			ctxt, err := base36.DecodeString(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := pfs.UncoverPadded(master.FrequencyKey, ctxt); err == nil {
+				realLengths[len(entry)] = true
+			} else {
+				dummyLengths[len(entry)] = true
+			}
+		}
+	}
+
+	if len(realLengths) != 2 {
+		t.Fatalf("Expected two real entry lengths, got %v", realLengths)
+	}
+	if !reflect.DeepEqual(realLengths, dummyLengths) {
+		t.Fatalf("Dummy lengths %v differ from real lengths %v", dummyLengths, realLengths)
+	}
+}
+
+func TestUnpaddedHasNoDetached(t *testing.T) {
+	payload, err := encryptFreeText(testMasterKey(t), EncryptOptions{}, noteContext{}, "no pneumonia")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := payload["detached_enc"]; ok {
+		t.Fatal("Expected no detached_enc without PadLength")
+	}
+}
+
+func TestSplitFreeTextDecimals(t *testing.T) {
+	text := "EF 3.5 cm. Size 2."
+
+	plain := strings.Join(SplitFreeText(text), " ")
+	if plain != "ef 3 5 cm size 2" {
+		t.Fatalf("Unexpected default tokens: %s", plain)
+	}
+
+	decimals := strings.Join(EncryptOptions{Ranges: true}.tokenizer().Split(text), " ")
+	if decimals != "ef 3.5 cm size 2" {
+		t.Fatalf("Unexpected range tokens: %s", decimals)
+	}
+}
+
+func TestSplitFreeTextPlaceholders(t *testing.T) {
+	text := "Seen by [NAME] on [DATE]"
+
+	plain := strings.Join(SplitFreeText(text), " ")
+	if plain != "seen by name on date" {
+		t.Fatalf("Unexpected default tokens: %s", plain)
+	}
+
+	scrubbed := strings.Join(EncryptOptions{PHI: &phiScrubber{}}.tokenizer().Split(text), " ")
+	if scrubbed != "seen by [NAME] on [DATE]" {
+		t.Fatalf("Unexpected scrubbed tokens: %s", scrubbed)
+	}
+}
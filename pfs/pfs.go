@@ -1,9 +1,16 @@
 package pfs
 
-import "github.com/agrinman/alvis/cryptutil"
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
 
 const KeySize = 256
 
+var dummyLabel = []byte("alvis-pfs-dummy")
+
 type MasterKey struct {
 	InnerKey    []byte
 	OuterKey    []byte
@@ -64,3 +71,68 @@ func UncoverCiphertext(master MasterKey, ciphertext Ciphertext) (result []byte,
 	result, err = Uncover(master, ciphertext.Detached)
 	return
 }
+
+// DisguisePadded pads the message to a multiple of bucket bytes before
+// disguising it, so that detached ciphertexts of words in the same bucket
+// have the same length. Hidden is the same as for Disguise.
+func DisguisePadded(master MasterKey, message []byte, bucket int) (result Ciphertext, err error) {
+	return DisguiseSalted(master, message, 0, bucket)
+}
+
+// DisguiseSalted disguises a message under one of several tags for it, so
+// that a frequent word can be spread over many tags. Salt 0 gives the same
+// tag as Disguise. A nonzero bucket pads the detached plaintext as
+// DisguisePadded does.
+func DisguiseSalted(master MasterKey, message []byte, salt uint32, bucket int) (result Ciphertext, err error) {
+	detached := message
+	if bucket > 0 {
+		detached = cryptutil.PadToBucket(message, bucket)
+	}
+
+	result.Detached, err = cryptutil.AESEncrypt(master.DetachedKey, detached)
+	if err != nil {
+		return
+	}
+
+	result.Hidden, err = cryptutil.AESEncrypt(master.OuterKey, Tag(master, message, salt))
+	return
+}
+
+// Tag is the value Recognize returns for a message disguised with salt
+func Tag(master MasterKey, message []byte, salt uint32) []byte {
+	if salt == 0 {
+		return cryptutil.H(message, master.InnerKey)
+	}
+
+	salted := make([]byte, len(message)+5)
+	copy(salted, message)
+	binary.BigEndian.PutUint32(salted[len(message)+1:], salt)
+
+	return cryptutil.H(salted, master.InnerKey)
+}
+
+func UncoverPadded(master MasterKey, detached []byte) (result []byte, err error) {
+	result, err = Uncover(master, detached)
+	if err != nil {
+		return
+	}
+
+	result, err = cryptutil.UnPadBucket(result)
+	return
+}
+
+// Dummy returns a hidden value for padding. Without the outer key it cannot
+// be told apart from a disguised word; with it, it is recognized as a dummy.
+func Dummy(outer []byte) (hidden []byte, err error) {
+	hidden, err = cryptutil.AESEncrypt(outer, dummyTag(outer))
+	return
+}
+
+// IsDummy checks whether a recognized value came from Dummy
+func IsDummy(outer []byte, recognized []byte) bool {
+	return bytes.Equal(recognized, dummyTag(outer))
+}
+
+func dummyTag(outer []byte) []byte {
+	return cryptutil.H(dummyLabel, outer)
+}
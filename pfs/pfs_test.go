@@ -64,3 +64,90 @@ func BenchmarkRecognize(b *testing.B) {
 	})
 
 }
+
+func TestPFSPadded(t *testing.T) {
+	master, err := Setup()
+	if err != nil {
+		t.Error(err)
+	}
+
+	short, err := DisguisePadded(master, []byte("mi"), 32)
+	if err != nil {
+		t.Error(err)
+	}
+
+	long, err := DisguisePadded(master, []byte("cardiomyopathy"), 32)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(short.Detached) != len(long.Detached) || len(short.Hidden) != len(long.Hidden) {
+		t.Errorf("Ciphertext lengths differ: %d/%d and %d/%d", len(short.Detached), len(short.Hidden), len(long.Detached), len(long.Hidden))
+	}
+
+	out, err := UncoverPadded(master, long.Detached)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bytes.Equal([]byte("cardiomyopathy"), out) == false {
+		t.Errorf("Output does not match orginal message.\nGot: %s\nExpected: %s", out, "cardiomyopathy")
+	}
+
+	unpadded, _ := Disguise(master, []byte("mi"))
+	r1, _ := Recognize(master.OuterKey, unpadded.Hidden)
+	r2, _ := Recognize(master.OuterKey, short.Hidden)
+	if bytes.Equal(r1, r2) == false {
+		t.Error("Padded and unpadded tokens are recognized differently")
+	}
+}
+
+func TestDummy(t *testing.T) {
+	master, _ := Setup()
+
+	dummy, err := Dummy(master.OuterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	real, _ := Disguise(master, []byte(longWord))
+	if len(dummy) != len(real.Hidden) {
+		t.Errorf("Dummy has length %d. Expected %d.", len(dummy), len(real.Hidden))
+	}
+
+	r, _ := Recognize(master.OuterKey, dummy)
+	if !IsDummy(master.OuterKey, r) {
+		t.Error("Dummy not recognized as dummy")
+	}
+
+	r, _ = Recognize(master.OuterKey, real.Hidden)
+	if IsDummy(master.OuterKey, r) {
+		t.Error("Word recognized as dummy")
+	}
+}
+
+func TestPFSSalted(t *testing.T) {
+	master, _ := Setup()
+	message := []byte("the")
+
+	plain, _ := Disguise(master, message)
+	zero, _ := DisguiseSalted(master, message, 0, 0)
+	one, _ := DisguiseSalted(master, message, 1, 32)
+
+	r, _ := Recognize(master.OuterKey, plain.Hidden)
+	r0, _ := Recognize(master.OuterKey, zero.Hidden)
+	r1, _ := Recognize(master.OuterKey, one.Hidden)
+
+	if !bytes.Equal(r, r0) || !bytes.Equal(r0, Tag(master, message, 0)) {
+		t.Error("Salt 0 is not recognized like Disguise")
+	}
+
+	if bytes.Equal(r0, r1) || !bytes.Equal(r1, Tag(master, message, 1)) {
+		t.Error("Salt 1 is not recognized as its own tag")
+	}
+
+	out, err := UncoverPadded(master, one.Detached)
+	if err != nil || !bytes.Equal(out, message) {
+		t.Errorf("Output does not match orginal message.\nGot: %s\nExpected: %s", out, message)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PHIRule replaces PHI with a typed placeholder like [NAME]. A rule matches
+// either a regular expression or any word of its dictionary, given inline
+// or as a file with one term per line.
+type PHIRule struct {
+	Type      string
+	Pattern   string
+	Words     []string
+	WordsFile string
+}
+
+// PHIConfig is the rules file format. Unless Defaults is false, Rules are
+// applied after the built-in rules.
+type PHIConfig struct {
+	Defaults *bool
+	Rules    []PHIRule
+}
+
+var defaultPHIRules = []PHIRule{
+	{Type: "EMAIL", Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+	{Type: "SSN", Pattern: `\b\d{3}-\d{2}-\d{4}\b`},
+	{Type: "PHONE", Pattern: `(?:\(\d{3}\)\s*|\b\d{3}[-.\s])\d{3}[-.\s]\d{4}\b`},
+	{Type: "MRN", Pattern: `(?i)\b(?:mrn|medical record(?: number)?)\s*(?:no\.?|number)?\s*[:#]?\s*[A-Za-z]?\d[\d-]*`},
+	{Type: "ADDRESS", Pattern: `(?i)\b\d+\s+(?:[a-z]+\s+){1,3}(?:street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|court|ct|way|place|pl)\b\.?`},
+	{Type: "NAME", Pattern: `\b(?:Mr|Mrs|Ms|Miss|Dr)\.?\s+[A-Z][a-z]+(?:\s+[A-Z][a-z]+)?`},
+}
+
+// placeholderPattern matches the placeholders left by the scrubber, which
+// the tokenizer of scrubbed notes keeps as single tokens.
+var placeholderPattern = regexp.MustCompile(`\[[A-Z_]+\]`)
+
+type phiMatcher struct {
+	Type    string
+	pattern *regexp.Regexp
+}
+
+// phiScrubber is the de-identification pass run on notes before they are
+// tokenized. A nil phiScrubber leaves notes untouched.
+type phiScrubber struct {
+	matchers []phiMatcher
+}
+
+func parsePHIConfig(filepath string) (scrubber *phiScrubber, err error) {
+	config := PHIConfig{}
+	if filepath != "" {
+		configBytes, readErr := ioutil.ReadFile(filepath)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+
+		err = json.Unmarshal(configBytes, &config)
+		if err != nil {
+			return
+		}
+	}
+
+	var rules []PHIRule
+	if config.Defaults == nil || *config.Defaults {
+		rules = append(rules, defaultPHIRules...)
+	}
+	rules = append(rules, config.Rules...)
+
+	scrubber = &phiScrubber{}
+	for _, rule := range rules {
+		matcher, ruleErr := compilePHIRule(rule)
+		if ruleErr != nil {
+			err = ruleErr
+			return
+		}
+		scrubber.matchers = append(scrubber.matchers, matcher)
+	}
+
+	return
+}
+
+func compilePHIRule(rule PHIRule) (matcher phiMatcher, err error) {
+	if !placeholderPattern.MatchString("[" + rule.Type + "]") {
+		err = errors.New(fmt.Sprintf("Invalid PHI type: %s. Expected upper case letters.", rule.Type))
+		return
+	}
+	matcher.Type = rule.Type
+
+	if rule.Pattern != "" {
+		matcher.pattern, err = regexp.Compile(rule.Pattern)
+		return
+	}
+
+	words := rule.Words
+	if rule.WordsFile != "" {
+		wordBytes, readErr := ioutil.ReadFile(rule.WordsFile)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		words = append(words, strings.Split(string(wordBytes), "\n")...)
+	}
+
+	var quoted []string
+	for _, w := range words {
+		w = strings.TrimSpace(w)
+		if len(w) > 0 {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+
+	if len(quoted) == 0 {
+		err = errors.New(fmt.Sprintf("PHI rule %s has no pattern or words", rule.Type))
+		return
+	}
+
+	// longest terms first, so "John Smith" wins over "John"
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+
+	matcher.pattern, err = regexp.Compile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return
+}
+
+// Scrub replaces all PHI in a note with placeholders, returning the number
+// of redactions of each type.
+func (s *phiScrubber) Scrub(text string) (scrubbed string, counts map[string]int) {
+	if s == nil {
+		return text, nil
+	}
+
+	counts = make(map[string]int)
+	for _, m := range s.matchers {
+		placeholder := "[" + m.Type + "]"
+		text = m.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			counts[m.Type] += 1
+			return placeholder
+		})
+	}
+
+	return text, counts
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestScrubDefaultRules(t *testing.T) {
+	scrubber, err := parsePHIConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrubbed, counts := scrubber.Scrub("Mr. John Smith (MRN: 12345) lives at 12 Main Street, call (617) 555-1234 or js@x.org. SSN 123-45-6789")
+
+	expected := "[NAME] ([MRN]) lives at [ADDRESS], call [PHONE] or [EMAIL]. SSN [SSN]"
+	if scrubbed != expected {
+		t.Fatalf("Scrubbed %q, expected %q", scrubbed, expected)
+	}
+
+	for _, phiType := range []string{"NAME", "MRN", "ADDRESS", "PHONE", "EMAIL", "SSN"} {
+		if counts[phiType] != 1 {
+			t.Errorf("Found %d %s. Expected 1.", counts[phiType], phiType)
+		}
+	}
+
+	// the placeholders are tokens of their own
+	tokens := tokenizer{Placeholders: true}.Split(scrubbed)
+	if len(tokens) != 11 || tokens[0] != "[NAME]" || tokens[10] != "[SSN]" {
+		t.Fatalf("Unexpected tokens: %v", tokens)
+	}
+}
+
+func TestScrubNil(t *testing.T) {
+	var scrubber *phiScrubber
+
+	if scrubbed, counts := scrubber.Scrub("MRN: 12345"); scrubbed != "MRN: 12345" || len(counts) != 0 {
+		t.Fatalf("Nil scrubber changed the note: %q", scrubbed)
+	}
+}
@@ -11,6 +11,9 @@ type PrivateKey struct {
 	Key     []byte
 }
 
+// CiphertextLen is the length of Hide's ciphertexts: an IV and one block
+const CiphertextLen = 32
+
 var OneVec = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
 
 //MARK: Private Encryption Keyword Search Methods
@@ -25,6 +28,15 @@ func (msk MasterKey) Extract(id string) (sk PrivateKey) {
 	return PrivateKey{id, cryptutil.H([]byte(id), msk.Key)}
 }
 
+// Domain derives an independent master key for a label, so that keys and
+// ciphertexts of one domain never match those of another. The hash inputs
+// are swapped from Extract's, so a domain key is never a keyword's key.
+func (msk MasterKey) Domain(label string) MasterKey {
+	return MasterKey{cryptutil.H(msk.Key, []byte(label))}
+}
+
+// Hide encrypts the fixed OneVec under the keyword's key, so ciphertexts
+// have the same length whatever the keyword and need no padding.
 func (msk MasterKey) Hide(id string) (res []byte, err error) {
 	sk := msk.Extract(id)
 	res, err = cryptutil.AESEncrypt(sk.Key, OneVec)
@@ -50,3 +62,15 @@ func (sk PrivateKey) Check(ctx []byte) bool {
 
 	return true
 }
+
+// Dummy returns a ciphertext for padding that has the length of Hide's but
+// matches no keyword.
+func Dummy() (res []byte, err error) {
+	key, err := cryptutil.RandKey()
+	if err != nil {
+		return
+	}
+
+	res, err = cryptutil.AESEncrypt(key, OneVec)
+	return
+}
@@ -83,3 +83,57 @@ func TestNewLineCarriageReturn(t *testing.T) {
 	}
 
 }
+
+func TestDummy(t *testing.T) {
+	master, _ := Setup()
+	c, _ := master.Hide(longWord)
+
+	d, err := Dummy()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(d) != len(c) {
+		t.Errorf("Dummy has length %d. Expected %d.", len(d), len(c))
+	}
+
+	if master.Extract(longWord).Check(d) {
+		t.Error("Error: dummy matched a keyword")
+	}
+}
+
+func TestDomain(t *testing.T) {
+	master, _ := Setup()
+	domain := master.Domain("prefix")
+
+	c, _ := domain.Hide("cardio")
+	if !domain.Extract("cardio").Check(c) {
+		t.Error("Error: domain key does not match its own domain")
+	}
+
+	if master.Extract("cardio").Check(c) {
+		t.Error("Error: master key matches a domain cipher text")
+	}
+
+	c, _ = master.Hide("cardio")
+	if domain.Extract("cardio").Check(c) {
+		t.Error("Error: domain key matches a master cipher text")
+	}
+}
+
+func TestDomainIsNotKeyword(t *testing.T) {
+	master, _ := Setup()
+	if string(master.Domain("prefix").Key) == string(master.Extract("prefix").Key) {
+		t.Error("Error: domain key equals the keyword key of its label")
+	}
+}
+
+func TestCiphertextLen(t *testing.T) {
+	master, _ := Setup()
+	c, _ := master.Hide(longWord)
+	d, _ := Dummy()
+	if len(c) != CiphertextLen || len(d) != CiphertextLen {
+		t.Errorf("Cipher text lengths %d and %d. Expected %d.", len(c), len(d), CiphertextLen)
+	}
+}
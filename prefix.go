@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/pks"
+)
+
+// prefixDomain separates prefix keys and ciphertexts from exact keywords
+const prefixDomain = "alvis-prefix"
+
+// prefixWildcard ends the keywords of prefix keys, like cardio*
+const prefixWildcard = "*"
+
+// parsePrefixLengths parses a list like "3,5,7" of prefix lengths in
+// characters
+func parsePrefixLengths(list string) (lengths []int, err error) {
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		n, convErr := strconv.Atoi(field)
+		if convErr != nil || n < 1 {
+			err = errors.New(fmt.Sprintf("Invalid prefix length: %s", field))
+			return
+		}
+		lengths = append(lengths, n)
+	}
+
+	sort.Ints(lengths)
+	return
+}
+
+// extractKeywordKey issues the key of an exact keyword, or of a prefix if
+// the word ends with *. Prefix keys only match stems whose length was one
+// of the prefix lengths at encryption.
+func extractKeywordKey(master MasterKey, word string) pks.PrivateKey {
+	if stem := strings.TrimSuffix(word, prefixWildcard); stem != word {
+		sk := master.KeywordKey.Domain(prefixDomain).Extract(stem)
+		sk.Keyword = word
+		return sk
+	}
+
+	return master.KeywordKey.Extract(word)
+}
+
+func isPrefixKey(sk pks.PrivateKey) bool {
+	return strings.HasSuffix(sk.Keyword, prefixWildcard)
+}
+
+//MARK: Prefix payloads
+
+// encryptPrefixes hides every prefix of the given lengths of every token,
+// one entry per token. Tokens shorter than a length get a dummy instead, so
+// entries do not reveal token lengths.
+func encryptPrefixes(master MasterKey, tokens []string, lengths []int) (entries []string, err error) {
+	domain := master.KeywordKey.Domain(prefixDomain)
+
+	for _, t := range tokens {
+		runes := []rune(t)
+
+		var joined []byte
+		for _, n := range lengths {
+			var ctxt []byte
+			if len(runes) >= n {
+				ctxt, err = domain.Hide(string(runes[:n]))
+			} else {
+				ctxt, err = pks.Dummy()
+			}
+			if err != nil {
+				return
+			}
+			joined = append(joined, ctxt...)
+		}
+
+		encoded, encErr := base36.EncodeFixed(joined)
+		if encErr != nil {
+			return nil, encErr
+		}
+		entries = append(entries, encoded)
+	}
+
+	return
+}
+
+// dummyPrefixToken pads the prefix entries of dummy tokens
+func dummyPrefixToken(lengths []int) func() (string, error) {
+	return func() (string, error) {
+		var joined []byte
+		for range lengths {
+			ctxt, err := pks.Dummy()
+			if err != nil {
+				return "", err
+			}
+			joined = append(joined, ctxt...)
+		}
+
+		return base36.EncodeFixed(joined)
+	}
+}
+
+// checkEntry is whether a key matches any of the ciphertexts joined in a
+// token's entry, like its prefixes
+func checkEntry(sk pks.PrivateKey, entry string) bool {
+	joined, err := base36.DecodeString(entry)
+	if err != nil {
+		return false
+	}
+
+	for i := 0; i+pks.CiphertextLen <= len(joined); i += pks.CiphertextLen {
+		if sk.Check(joined[i : i+pks.CiphertextLen]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPrefixEntriesFixedWidth(t *testing.T) {
+	master := testMasterKey(t)
+
+	entries, err := encryptPrefixes(master, []string{"cardiomyopathy", "mi", "cardiac"}, []int{4, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dummy, err := dummyPrefixToken([]int{4, 6})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range append(entries, dummy) {
+		if len(entry) != len(entries[0]) {
+			t.Fatalf("Prefix entries differ in length: %s, %s", entry, entries[0])
+		}
+	}
+
+	sk := extractKeywordKey(master, "card*")
+	if !checkEntry(sk, entries[0]) || checkEntry(sk, entries[1]) || !checkEntry(sk, entries[2]) || checkEntry(sk, dummy) {
+		t.Fatal("Prefix key matched the wrong entries")
+	}
+}
@@ -0,0 +1,106 @@
+package prs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/pks"
+)
+
+// Bits is the size of the value domain: values are in [0, 2^Bits)
+const Bits = 24
+
+// MaxValue is the largest value that can be hidden
+const MaxValue = 1<<Bits - 1
+
+type MasterKey struct {
+	Key []byte
+}
+
+// RangeKey matches the values in [Lo, Hi]. It holds the keys of the dyadic
+// intervals covering the range, at most two per level.
+type RangeKey struct {
+	Label string
+	Lo    uint32
+	Hi    uint32
+	Nodes []NodeKey
+}
+
+// NodeKey is the key of the dyadic interval of the values sharing their bits
+// above Level
+type NodeKey struct {
+	Level int
+	pks.PrivateKey
+}
+
+//MARK: Range Searchable Encryption Methods
+
+func Setup() (msk MasterKey, err error) {
+	msk.Key, err = cryptutil.RandKey()
+	return
+}
+
+func nodeID(level int, prefix uint32) string {
+	return fmt.Sprintf("%d:%d", level, prefix)
+}
+
+// Hide encrypts a value as the keyword ciphertexts of the Bits+1 dyadic
+// intervals containing it, from the value itself up to the whole domain.
+// Ciphertexts are randomized, so equal values cannot be linked.
+func (msk MasterKey) Hide(value uint32) (ctxts [][]byte, err error) {
+	if value > MaxValue {
+		err = errors.New(fmt.Sprintf("Value %d is out of range", value))
+		return
+	}
+
+	for level := 0; level <= Bits; level++ {
+		ctxt, hideErr := pks.MasterKey(msk).Hide(nodeID(level, value>>uint(level)))
+		if hideErr != nil {
+			return nil, hideErr
+		}
+		ctxts = append(ctxts, ctxt)
+	}
+
+	return
+}
+
+// Extract issues the key of the values in [lo, hi]
+func (msk MasterKey) Extract(label string, lo uint32, hi uint32) (rk RangeKey, err error) {
+	if lo > hi || hi > MaxValue {
+		err = errors.New(fmt.Sprintf("Invalid range [%d, %d]", lo, hi))
+		return
+	}
+
+	rk = RangeKey{Label: label, Lo: lo, Hi: hi}
+
+	// greedily take the largest aligned interval starting at next
+	next, end := uint64(lo), uint64(hi)+1
+	for next < end {
+		level := 0
+		for level < Bits && next%(1<<uint(level+1)) == 0 && next+(1<<uint(level+1)) <= end {
+			level += 1
+		}
+
+		sk := pks.MasterKey(msk).Extract(nodeID(level, uint32(next>>uint(level))))
+		rk.Nodes = append(rk.Nodes, NodeKey{level, sk})
+		next += 1 << uint(level)
+	}
+
+	return
+}
+
+// Check is whether the hidden value is in the key's range
+func (rk RangeKey) Check(ctxts [][]byte) bool {
+	if len(ctxts) != Bits+1 {
+		return false
+	}
+
+	for _, node := range rk.Nodes {
+		if node.Check(ctxts[node.Level]) {
+			return true
+		}
+	}
+
+	return false
+}
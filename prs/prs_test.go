@@ -0,0 +1,54 @@
+package prs
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	msk, err := Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := [][2]uint32{{0, 350}, {351, MaxValue}, {17, 17}, {0, MaxValue}, {1000, 1023}}
+	values := []uint32{0, 17, 350, 351, 1000, 1023, 1024, MaxValue}
+	for i := 0; i < 20; i++ {
+		values = append(values, uint32(rand.Intn(2000)))
+	}
+
+	for _, r := range ranges {
+		rk, err := msk.Extract("r", r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rk.Nodes) > 2*Bits {
+			t.Errorf("Range [%d, %d] has %d nodes", r[0], r[1], len(rk.Nodes))
+		}
+
+		for _, v := range values {
+			ctxts, err := msk.Hide(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := v >= r[0] && v <= r[1]
+			if rk.Check(ctxts) != expected {
+				t.Errorf("Check(%d) in [%d, %d] = %t. Expected %t.", v, r[0], r[1], !expected, expected)
+			}
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	msk, _ := Setup()
+
+	if _, err := msk.Hide(MaxValue + 1); err == nil {
+		t.Error("Expected an error for a value out of range")
+	}
+
+	if _, err := msk.Extract("r", 10, 5); err == nil {
+		t.Error("Expected an error for an empty range")
+	}
+}
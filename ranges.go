@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/prs"
+
+	"github.com/fatih/color"
+)
+
+// rangeScale is the fixed point precision of range searchable values, so
+// "35.5" is hidden as 355.
+const rangeScale = 10
+
+// rangeKeyExt marks range key files in a key directory
+const rangeKeyExt = ".rk"
+
+// numericPattern matches the numeric tokens of SplitFreeText, like 30 or 55%
+var numericPattern = regexp.MustCompile(`^\d+(\.\d+)?%?$`)
+
+// RangeKey derives the range searchable encryption key from the master key
+func (msk MasterKey) RangeKey() prs.MasterKey {
+	return prs.MasterKey{Key: msk.subKey("alvis-range")}
+}
+
+// rangeValue is the fixed point value of a number, if it is in the domain
+func rangeValue(x float64) (value uint32, ok bool) {
+	scaled := math.Round(x * rangeScale)
+	if scaled < 0 || scaled > prs.MaxValue {
+		return
+	}
+
+	return uint32(scaled), true
+}
+
+func numericTokenValue(token string) (value uint32, ok bool) {
+	if !numericPattern.MatchString(token) {
+		return
+	}
+
+	x, err := strconv.ParseFloat(strings.TrimSuffix(token, "%"), 64)
+	if err != nil {
+		return
+	}
+
+	return rangeValue(x)
+}
+
+//MARK: Range payloads
+
+// encryptRanges hides the value of every numeric token. Entries are
+// "position:ciphertext", positions counting the dummies spliced in by mask,
+// if any. Which tokens are numbers is not hidden.
+func encryptRanges(master MasterKey, tokens []string, mask []bool) (entries []string, err error) {
+	positions := maskPositions(mask, len(tokens))
+
+	rangeKey := master.RangeKey()
+	for i, t := range tokens {
+		value, ok := numericTokenValue(t)
+		if !ok {
+			continue
+		}
+
+		ctxts, hideErr := rangeKey.Hide(value)
+		if hideErr != nil {
+			return nil, hideErr
+		}
+
+		var joined []byte
+		for _, ctxt := range ctxts {
+			joined = append(joined, ctxt...)
+		}
+
+		encoded, encErr := base36.EncodeFixed(joined)
+		if encErr != nil {
+			return nil, encErr
+		}
+
+		entries = append(entries, fmt.Sprintf("%d:%s", positions[i], encoded))
+	}
+
+	return
+}
+
+// rangeHits checks the numeric tokens of a payload against the range keys,
+// returning the label of the first matching key by token position.
+func rangeHits(inMap map[string]interface{}, rangeKeys []prs.RangeKey) (hits map[int]string) {
+	hits = make(map[int]string)
+	if len(rangeKeys) == 0 {
+		return
+	}
+
+	for _, entry := range payloadTokens(inMap, "range_enc") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		position, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+
+		joined, err := base36.DecodeString(parts[1])
+		if err != nil || len(joined)%(prs.Bits+1) != 0 {
+			color.Red("Cannot decode range cipher text at %d", position)
+			continue
+		}
+
+		size := len(joined) / (prs.Bits + 1)
+		var ctxts [][]byte
+		for i := 0; i < len(joined); i += size {
+			ctxts = append(ctxts, joined[i:i+size])
+		}
+
+		for _, rk := range rangeKeys {
+			if rk.Check(ctxts) {
+				hits[position] = rk.Label
+				break
+			}
+		}
+	}
+
+	return
+}
+
+//MARK: Range keys
+
+// rangeKeyBounds are the bounds of a range key from -min and -max, at least
+// one of which must be given. A key over every value would reveal them all.
+func rangeKeyBounds(hasMin bool, min float64, hasMax bool, max float64) (lo uint32, hi uint32, err error) {
+	if !hasMin && !hasMax {
+		err = errors.New("A range key needs '-min', '-max' or both")
+		return
+	}
+
+	lo, hi = 0, prs.MaxValue
+	if hasMin {
+		var ok bool
+		if lo, ok = rangeValue(min); !ok {
+			err = errors.New("'-min' is out of range")
+			return
+		}
+	}
+	if hasMax {
+		var ok bool
+		if hi, ok = rangeValue(max); !ok {
+			err = errors.New("'-max' is out of range")
+			return
+		}
+	}
+
+	if lo > hi {
+		err = errors.New(fmt.Sprintf("'-min' %g is above '-max' %g", min, max))
+		return
+	}
+
+	if lo == 0 && hi == prs.MaxValue {
+		err = errors.New("The range covers every value, so its key would reveal them all")
+	}
+	return
+}
+
+// rangeLabel names a range key by its bounds, like 0..34.9
+func rangeLabel(lo uint32, hi uint32) string {
+	return fmt.Sprintf("%g..%g", float64(lo)/rangeScale, float64(hi)/rangeScale)
+}
+
+// readRangeKeys reads every range key (*.rk) in a directory of keys
+func readRangeKeys(keyDirPath string) (rangeKeys []prs.RangeKey, err error) {
+	// key bundles only hold keyword keys
+	if !isKeyDir(keyDirPath) {
+		return
+	}
+
+	files, err := ioutil.ReadDir(keyDirPath)
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		if path.Ext(f.Name()) != rangeKeyExt {
+			continue
+		}
+
+		keyBytes, readErr := ioutil.ReadFile(path.Join(keyDirPath, f.Name()))
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		var rk prs.RangeKey
+		err = json.Unmarshal(keyBytes, &rk)
+		if err != nil {
+			return
+		}
+		rangeKeys = append(rangeKeys, rk)
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/prs"
+)
+
+func TestRangeEntriesFixedWidth(t *testing.T) {
+	master := testMasterKey(t)
+
+	entries, err := encryptRanges(master, []string{"ef", "1", "and", "1000.5", "35"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 numeric tokens, got %v", entries)
+	}
+
+	width := len(strings.SplitN(entries[0], ":", 2)[1])
+	for _, entry := range entries {
+		if len(strings.SplitN(entry, ":", 2)[1]) != width {
+			t.Fatalf("Range entries differ in length: %v", entries)
+		}
+	}
+}
+
+func TestRangeKeyBounds(t *testing.T) {
+	if _, _, err := rangeKeyBounds(false, 0, false, 0); err == nil {
+		t.Fatal("Expected an error without bounds")
+	}
+
+	if _, _, err := rangeKeyBounds(true, 50, true, 10); err == nil {
+		t.Fatal("Expected an error for min above max")
+	}
+
+	if _, _, err := rangeKeyBounds(true, 0, false, 0); err == nil {
+		t.Fatal("Expected an error for a range over every value")
+	}
+
+	lo, hi, err := rangeKeyBounds(true, 10, true, 34.9)
+	if err != nil || lo != 100 || hi != 349 {
+		t.Fatalf("Unexpected bounds [%d, %d]: %v", lo, hi, err)
+	}
+}
+
+func TestRangeKeyCoverage(t *testing.T) {
+	master := testMasterKey(t)
+
+	lo, hi, err := rangeKeyBounds(true, 10, true, 34.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rangeKey, err := master.RangeKey().Extract("10..34.9", lo, hi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := []string{"9.9", "10", "22.5", "34.9", "35", "100"}
+	entries, err := encryptRanges(master, tokens, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := rangeHits(map[string]interface{}{"range_enc": entries}, []prs.RangeKey{rangeKey})
+	for i, token := range tokens {
+		_, hit := hits[i]
+		if want := i >= 1 && i <= 3; hit != want {
+			t.Fatalf("Range key hit %s: %v, expected %v", token, hit, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/agrinman/alvis/cryptutil"
+)
+
+// analystKeyType identifies analyst key files
+const analystKeyType = "alvis-x25519"
+
+// Formats of key files sealed to their recipient, bound to their contents
+const (
+	keywordKeyFormat   = "alvis-keyword-key"
+	frequencyKeyFormat = "alvis-frequency-key"
+)
+
+// analystKey is an analyst's X25519 key file: the identity, with the
+// private key, or the public key that keys are sealed to.
+type analystKey struct {
+	Type    string
+	Public  []byte
+	Private []byte `json:",omitempty"`
+}
+
+// envelopeKey seals and opens key files: a symmetric bundle key, or an
+// analyst's X25519 public key to seal and private key to open.
+type envelopeKey struct {
+	Bundle  []byte
+	Public  *ecdh.PublicKey
+	Private *ecdh.PrivateKey
+}
+
+func (k envelopeKey) isSet() bool {
+	return k.Bundle != nil || k.Public != nil || k.Private != nil
+}
+
+func newAnalystKey() (identity analystKey, public analystKey, err error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+
+	identity = analystKey{analystKeyType, private.PublicKey().Bytes(), private.Bytes()}
+	public = analystKey{Type: analystKeyType, Public: private.PublicKey().Bytes()}
+	return
+}
+
+// readEnvelopeKey reads a bundle key, or an analyst's identity or public
+// key file.
+func readEnvelopeKey(keyPath string) (key envelopeKey, err error) {
+	keyBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return
+	}
+
+	var ak analystKey
+	if json.Unmarshal(keyBytes, &ak) != nil || ak.Type != analystKeyType {
+		key.Bundle, err = readBundleKey(keyPath)
+		return
+	}
+
+	if ak.Private != nil {
+		key.Private, err = ecdh.X25519().NewPrivateKey(ak.Private)
+		if err != nil {
+			return
+		}
+		key.Public = key.Private.PublicKey()
+		return
+	}
+
+	key.Public, err = ecdh.X25519().NewPublicKey(ak.Public)
+	return
+}
+
+//MARK: Envelopes
+
+// sealEnvelope seals a key file to its recipient. For analyst keys, the
+// sealing key is derived from an ephemeral X25519 exchange, as in age.
+func sealEnvelope(format string, contents []byte, to envelopeKey) (envelopeBytes []byte, err error) {
+	envelope := keyEnvelope{Format: format, Version: keyEnvelopeVersion}
+
+	sealingKey := to.Bundle
+	if to.Public != nil {
+		ephemeral, genErr := ecdh.X25519().GenerateKey(rand.Reader)
+		if genErr != nil {
+			return nil, genErr
+		}
+
+		shared, ecdhErr := ephemeral.ECDH(to.Public)
+		if ecdhErr != nil {
+			return nil, ecdhErr
+		}
+		sealingKey = exchangeKey(shared, ephemeral.PublicKey(), to.Public)
+
+		envelope.Recipient = keyID(to.Public.Bytes())
+		envelope.Ephemeral = ephemeral.PublicKey().Bytes()
+	} else {
+		envelope.Recipient = keyID(sealingKey)
+	}
+
+	envelope.Sealed, err = cryptutil.Seal(sealingKey, contents, []byte(format))
+	if err != nil {
+		return
+	}
+
+	return json.Marshal(envelope)
+}
+
+// exchangeKey derives the sealing key of an envelope from the shared
+// secret, bound to both public keys.
+func exchangeKey(shared []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) []byte {
+	return cryptutil.H(shared, append(ephemeral.Bytes(), recipient.Bytes()...))
+}
+
+// parseEnvelope is whether the contents of a key file are an envelope
+func parseEnvelope(data []byte) (envelope keyEnvelope, ok bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return
+	}
+
+	if json.Unmarshal(data, &envelope) != nil || envelope.Sealed == nil {
+		return
+	}
+
+	return envelope, true
+}
+
+// openEnvelope opens a key file of the given format with the bundle key or
+// identity it was sealed to.
+func openEnvelope(envelope keyEnvelope, format string, opener envelopeKey) (contents []byte, err error) {
+	if envelope.Format != format {
+		err = errors.New(fmt.Sprintf("Expected a sealed %s, got %s", format, envelope.Format))
+		return
+	}
+
+	if envelope.Version != keyEnvelopeVersion {
+		err = errors.New(fmt.Sprintf("Unsupported %s version %d", format, envelope.Version))
+		return
+	}
+
+	var sealingKey []byte
+	if envelope.Ephemeral != nil {
+		if opener.Private == nil {
+			err = errors.New(fmt.Sprintf("Sealed %s: missing the '-identity' of analyst key %s", format, envelope.Recipient))
+			return
+		}
+
+		if envelope.Recipient != keyID(opener.Public.Bytes()) {
+			err = errors.New(fmt.Sprintf("Sealed %s is for analyst key %s, not %s", format, envelope.Recipient, keyID(opener.Public.Bytes())))
+			return
+		}
+
+		ephemeral, keyErr := ecdh.X25519().NewPublicKey(envelope.Ephemeral)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+
+		shared, ecdhErr := opener.Private.ECDH(ephemeral)
+		if ecdhErr != nil {
+			return nil, ecdhErr
+		}
+		sealingKey = exchangeKey(shared, ephemeral, opener.Public)
+	} else {
+		if opener.Bundle == nil {
+			err = errors.New(fmt.Sprintf("Sealed %s: missing the '-bundle-key' %s", format, envelope.Recipient))
+			return
+		}
+
+		if envelope.Recipient != keyID(opener.Bundle) {
+			err = errors.New(fmt.Sprintf("Sealed %s is for bundle key %s, not %s", format, envelope.Recipient, keyID(opener.Bundle)))
+			return
+		}
+		sealingKey = opener.Bundle
+	}
+
+	contents, err = cryptutil.Open(sealingKey, envelope.Sealed, []byte(format))
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Cannot open sealed %s: %s", format, err))
+	}
+	return
+}
+
+// readFrequencyKey reads a frequency key, opening it if it was sealed
+func readFrequencyKey(keyPath string, opener envelopeKey) (freqOuter []byte, err error) {
+	freqOuter, err = ioutil.ReadFile(keyPath)
+	if err != nil {
+		return
+	}
+
+	if envelope, ok := parseEnvelope(freqOuter); ok {
+		return openEnvelope(envelope, frequencyKeyFormat, opener)
+	}
+	return
+}
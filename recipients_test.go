@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// testEnvelopeKeys writes a new analyst identity and public key, and reads
+// them back as envelope keys.
+func testEnvelopeKeys(t *testing.T, dir string, name string) (public envelopeKey, identity envelopeKey) {
+	id, pub, err := newAnalystKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, key := range map[string]analystKey{name + ".id": id, name + ".pub": pub} {
+		keyBytes, _ := json.Marshal(key)
+		ioutil.WriteFile(path.Join(dir, filename), keyBytes, 0600)
+	}
+
+	public, err = readEnvelopeKey(path.Join(dir, name+".pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.Public == nil || public.Private != nil {
+		t.Fatal("Public key file read with a private key")
+	}
+
+	identity, err = readEnvelopeKey(path.Join(dir, name+".id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSealedFrequencyKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-recipients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	to, opener := testEnvelopeKeys(t, dir, "analyst")
+	_, wrong := testEnvelopeKeys(t, dir, "other")
+
+	sealed, err := sealEnvelope(frequencyKeyFormat, master.FrequencyKey.OuterKey, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := path.Join(dir, "freq.key")
+	ioutil.WriteFile(keyPath, sealed, 0600)
+
+	freqOuter, err := readFrequencyKey(keyPath, opener)
+	if err != nil || !bytes.Equal(freqOuter, master.FrequencyKey.OuterKey) {
+		t.Fatalf("Cannot open the sealed frequency key: %v", err)
+	}
+
+	if _, err = readFrequencyKey(keyPath, wrong); err == nil {
+		t.Fatal("Opened a frequency key with the wrong identity")
+	}
+	if _, err = readFrequencyKey(keyPath, envelopeKey{}); err == nil {
+		t.Fatal("Opened a frequency key without an identity")
+	}
+
+	// a key relabeled for another analyst still does not open with theirs
+	envelope, _ := parseEnvelope(sealed)
+	envelope.Recipient = keyID(wrong.Public.Bytes())
+	if _, err = openEnvelope(envelope, frequencyKeyFormat, wrong); err == nil {
+		t.Fatal("Opened a relabeled frequency key with the wrong identity")
+	}
+
+	// nor does it open as another kind of key
+	if _, err = openEnvelope(envelope, keywordKeyFormat, opener); err == nil {
+		t.Fatal("Opened a frequency key as a keyword key")
+	}
+}
+
+func TestSealedKeyBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-recipients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	to, opener := testEnvelopeKeys(t, dir, "analyst")
+	_, wrong := testEnvelopeKeys(t, dir, "other")
+
+	bundle, err := newKeyBundle(master, []string{"stemi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundlePath := path.Join(dir, "keys.akb")
+	err = writeKeyBundle(bundle, to, bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened, openErr := readKeyBundle(bundlePath, opener); openErr != nil || len(opened.Keys) != 1 {
+		t.Fatalf("Cannot open the sealed bundle: %v", openErr)
+	}
+	if _, err = readKeyBundle(bundlePath, wrong); err == nil {
+		t.Fatal("Opened a bundle with the wrong identity")
+	}
+}
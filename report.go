@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// runReport summarizes an encrypt run. It never holds plaintext, only
+// counts and notes about what was done.
+type runReport struct {
+	mutex sync.Mutex
+
+	Files      int
+	Notes      int
+	Redactions map[string]map[string]int `json:",omitempty"`
+
+	// Leakage notes what the chosen options reveal beyond exact keywords
+	Leakage []string `json:",omitempty"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{Redactions: make(map[string]map[string]int)}
+}
+
+// newEncryptReport is the report of an encrypt run, when it is written or
+// options have something to report: PHI redactions or fuzzy leakage.
+func newEncryptReport(reportPath string, opts EncryptOptions) (report *runReport) {
+	if reportPath == "" && opts.PHI == nil && opts.FuzzyDistance == 0 {
+		return
+	}
+
+	report = newRunReport()
+	if opts.FuzzyDistance > 0 {
+		report.addLeakage(fuzzyLeakage(opts.FuzzyDistance)...)
+	}
+	return
+}
+
+func (r *runReport) addFile() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.Files += 1
+	r.mutex.Unlock()
+}
+
+// addNote records one encrypted note of a file and its PHI redactions
+func (r *runReport) addNote(file string, redactions map[string]int) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Notes += 1
+	if len(redactions) == 0 {
+		return
+	}
+
+	if r.Redactions[file] == nil {
+		r.Redactions[file] = make(map[string]int)
+	}
+	for t, c := range redactions {
+		r.Redactions[file][t] += c
+	}
+}
+
+// addLeakage notes something the encrypted output reveals
+func (r *runReport) addLeakage(notes ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.Leakage = append(r.Leakage, notes...)
+	r.mutex.Unlock()
+}
+
+// printLeakage prints the leakage notes, which hold no plaintext
+func (r *runReport) printLeakage(w io.Writer) {
+	if r == nil || len(r.Leakage) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "-- leakage --")
+	for _, note := range r.Leakage {
+		fmt.Fprintf(w, "- %s\n", note)
+	}
+}
+
+// redactionTotals sums the redactions of each PHI type over all files
+func (r *runReport) redactionTotals() map[string]int {
+	totals := make(map[string]int)
+	for _, counts := range r.Redactions {
+		for t, c := range counts {
+			totals[t] += c
+		}
+	}
+	return totals
+}
+
+func (r *runReport) write(filepath string) (err error) {
+	reportBytes, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(filepath, reportBytes, 0660)
+	return
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Schema describes how the fields of data files are treated. Columns of
+// tabular files that are neither free text nor deterministic are passed
+// through. Fields are dotted paths into JSON and FHIR files, or segment
+// fields like "PID-3" for HL7. Without a patient field or column, the file
+// name identifies the patient. Note dates are read from NoteDateColumn, or
+// from NoteDateFields relative to each note (JSON), resource (FHIR) or OBX
+// segment (HL7).
+type Schema struct {
+	Delimiter      string
+	LazyQuotes     bool
+	TextColumns    []string
+	DetColumns     []string
+	DateColumns    []string
+	PatientColumn  string
+	NoteDateColumn string
+
+	DetFields    []string
+	DateFields   []string
+	PatientField string
+
+	NoteDateFields []string
+
+	// SectionHeaders are the section headers of notes by record type: the
+	// column of tabular files, the resource type of FHIR and OBX for HL7.
+	// "*" is for any other.
+	SectionHeaders map[string][]string
+}
+
+var defaultSchema = Schema{
+	Delimiter:   ",",
+	TextColumns: []string{"note_text"},
+}
+
+func parseSchema(filepath string) (schema Schema, err error) {
+	schema = defaultSchema
+	if filepath == "" {
+		return
+	}
+
+	schemaBytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+
+	// unmarshall schema over the defaults
+	err = json.Unmarshal(schemaBytes, &schema)
+
+	return
+}
+
+// hl7PatientField is the schema's patient field, or PID-3 which identifies
+// the patient of HL7 messages
+func (s Schema) hl7PatientField() string {
+	if s.PatientField != "" {
+		return s.PatientField
+	}
+	return "PID-3"
+}
+
+// noteDateFields are the schema's note date fields, or the usual ones of
+// the format.
+func (s Schema) noteDateFields(format string) []string {
+	if len(s.NoteDateFields) > 0 {
+		return s.NoteDateFields
+	}
+
+	switch format {
+	case "fhir":
+		return []string{"date", "effectiveDateTime", "issued"}
+	case "hl7":
+		return []string{"OBX-14"}
+	case "csv":
+		return nil
+	}
+
+	return []string{"date"}
+}
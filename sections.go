@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/pks"
+)
+
+// Section label policies of encryption
+const (
+	sectionsPlain     = "plain"
+	sectionsEncrypted = "encrypted"
+)
+
+// sectionDomain separates section keys and ciphertexts from keywords
+const sectionDomain = "alvis-section"
+
+// sectionKeyExt marks section keys in a key directory
+const sectionKeyExt = ".sec"
+
+// defaultSectionHeaders are the usual headers of each record type, and of
+// any other under "*". Schema.SectionHeaders replaces them.
+var defaultSectionHeaders = map[string][]string{
+	"Rad": {"INDICATION", "CLINICAL HISTORY", "TECHNIQUE", "COMPARISON", "FINDINGS", "IMPRESSION"},
+	"Pat": {"CLINICAL HISTORY", "GROSS DESCRIPTION", "MICROSCOPIC DESCRIPTION", "DIAGNOSIS", "FINAL DIAGNOSIS", "COMMENT"},
+	"Dis": {"CHIEF COMPLAINT", "HISTORY OF PRESENT ILLNESS", "PAST MEDICAL HISTORY", "HOSPITAL COURSE", "MEDICATIONS", "DISCHARGE MEDICATIONS", "ALLERGIES", "DISCHARGE DIAGNOSIS", "FOLLOW UP"},
+	"Car": {"INDICATION", "HISTORY", "MEDICATIONS", "FINDINGS", "IMPRESSION", "ASSESSMENT", "PLAN"},
+	"*":   {"HISTORY", "HISTORY OF PRESENT ILLNESS", "PAST MEDICAL HISTORY", "MEDICATIONS", "ALLERGIES", "FINDINGS", "IMPRESSION", "ASSESSMENT", "PLAN", "ASSESSMENT AND PLAN", "DIAGNOSIS"},
+}
+
+// sectionHeaders are the headers of a record type, from the schema if it
+// has any, or else the defaults.
+func (s Schema) sectionHeaders(record string) []string {
+	for _, headers := range []map[string][]string{s.SectionHeaders, defaultSectionHeaders} {
+		if h, ok := headers[record]; ok {
+			return h
+		}
+		if h, ok := headers["*"]; ok {
+			return h
+		}
+	}
+	return nil
+}
+
+// sectionLabel names a section by its header, like history_of_present_illness
+func sectionLabel(header string) string {
+	return strings.Join(strings.Fields(strings.ToLower(header)), "_")
+}
+
+// sectionPatterns caches the compiled header patterns by header list
+var sectionPatterns sync.Map
+
+// sectionPattern matches any of the headers followed by a colon, at the
+// start of the text or after a non word character.
+func sectionPattern(headers []string) *regexp.Regexp {
+	cacheKey := strings.Join(headers, "\x00")
+	if pattern, ok := sectionPatterns.Load(cacheKey); ok {
+		return pattern.(*regexp.Regexp)
+	}
+
+	// longest first, so HISTORY OF PRESENT ILLNESS wins over HISTORY
+	sorted := append([]string{}, headers...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	var alternatives []string
+	for _, h := range sorted {
+		var words []string
+		for _, w := range strings.Fields(h) {
+			words = append(words, regexp.QuoteMeta(w))
+		}
+		alternatives = append(alternatives, strings.Join(words, `\s+`))
+	}
+
+	pattern := regexp.MustCompile(`(?i)(?:^|[^\pL\pN_'"%])(` + strings.Join(alternatives, "|") + `)\s*:`)
+	sectionPatterns.Store(cacheKey, pattern)
+	return pattern
+}
+
+// detectSections is the section label of each of the tokens of a note, ""
+// before the first header. If the sections do not tokenize like the whole
+// note, no sections are found.
+func detectSections(tokenize tokenizer, text string, tokens []string, headers []string) (labels []string) {
+	labels = make([]string, len(tokens))
+	if len(headers) == 0 {
+		return
+	}
+
+	matches := sectionPattern(headers).FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	var sectioned []string
+	start, label := 0, ""
+	for _, m := range matches {
+		for range tokenize.Split(text[start:m[2]]) {
+			sectioned = append(sectioned, label)
+		}
+		start, label = m[2], sectionLabel(text[m[2]:m[3]])
+	}
+	for range tokenize.Split(text[start:]) {
+		sectioned = append(sectioned, label)
+	}
+
+	if len(sectioned) == len(tokens) {
+		labels = sectioned
+	}
+	return
+}
+
+//MARK: Section payloads
+
+// plainSections lists where each section starts, as "position:label",
+// positions counting the dummies spliced in by mask, if any.
+func plainSections(labels []string, mask []bool) (entries []string) {
+	positions := maskPositions(mask, len(labels))
+	for i, label := range labels {
+		if label != "" && (i == 0 || labels[i-1] != label) {
+			entries = append(entries, fmt.Sprintf("%d:%s", positions[i], label))
+		}
+	}
+	return
+}
+
+// encryptSections hides the section label of every token, so only section
+// key holders learn where sections are. Tokens outside sections get a
+// dummy.
+func encryptSections(master MasterKey, labels []string) (entries []string, err error) {
+	domain := master.KeywordKey.Domain(sectionDomain)
+
+	for _, label := range labels {
+		var ctxt []byte
+		if label != "" {
+			ctxt, err = domain.Hide(label)
+		} else {
+			ctxt, err = pks.Dummy()
+		}
+		if err != nil {
+			return
+		}
+
+		entry, encErr := base36.EncodeFixed(ctxt)
+		if encErr != nil {
+			return nil, encErr
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// noteSections is the section label of each of the n tokens of a payload:
+// plaintext labels, or those of the section keys matching encrypted ones.
+func noteSections(inMap map[string]interface{}, n int, sectionKeys []pks.PrivateKey) (labels []string) {
+	labels = make([]string, n)
+
+	type start struct {
+		position int
+		label    string
+	}
+	var starts []start
+	for _, entry := range payloadTokens(inMap, "sections") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if position, err := strconv.Atoi(parts[0]); err == nil {
+			starts = append(starts, start{position, parts[1]})
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].position < starts[j].position })
+
+	for s, st := range starts {
+		end := n
+		if s+1 < len(starts) {
+			end = starts[s+1].position
+		}
+		for i := st.position; i < end && i < n; i++ {
+			labels[i] = st.label
+		}
+	}
+
+	if len(sectionKeys) == 0 {
+		return
+	}
+
+	for i, entry := range payloadTokens(inMap, "section_enc") {
+		if i >= n {
+			break
+		}
+
+		ctxt, err := base36.DecodeString(entry)
+		if err != nil {
+			continue
+		}
+
+		for _, sk := range sectionKeys {
+			if sk.Check(ctxt) {
+				labels[i] = sk.Keyword
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// inSections is whether a label is one of the sections searched, or any
+// label if none are given
+func inSections(label string, sections []string) bool {
+	if len(sections) == 0 {
+		return true
+	}
+
+	for _, s := range sections {
+		if s == label {
+			return true
+		}
+	}
+	return false
+}
+
+//MARK: Section keys
+
+// extractSectionKey issues the key revealing a section's encrypted label
+func extractSectionKey(master MasterKey, label string) pks.PrivateKey {
+	return master.KeywordKey.Domain(sectionDomain).Extract(sectionLabel(label))
+}
+
+// readSectionKeys reads every section key (*.sec) in a directory of keys
+func readSectionKeys(keyDirPath string) (sectionKeys []pks.PrivateKey, err error) {
+	// key bundles only hold keyword keys
+	if !isKeyDir(keyDirPath) {
+		return
+	}
+
+	files, err := ioutil.ReadDir(keyDirPath)
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		if path.Ext(f.Name()) != sectionKeyExt {
+			continue
+		}
+
+		keyBytes, readErr := ioutil.ReadFile(path.Join(keyDirPath, f.Name()))
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		var sk pks.PrivateKey
+		err = json.Unmarshal(keyBytes, &sk)
+		if err != nil {
+			return
+		}
+		sectionKeys = append(sectionKeys, sk)
+	}
+
+	return
+}
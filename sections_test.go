@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+func TestDetectSections(t *testing.T) {
+	text := "FINDINGS: nodule. IMPRESSION: possible pneumonia. EF 55%"
+	labels := detectSections(tokenizer{}, text, SplitFreeText(text), defaultSchema.sectionHeaders("Rad"))
+
+	expected := "findings findings impression impression impression impression impression"
+	if strings.Join(labels, " ") != expected {
+		t.Fatalf("Sections %v, expected %s", labels, expected)
+	}
+}
+
+func TestEncryptedSectionsRoundTrip(t *testing.T) {
+	master := testMasterKey(t)
+
+	payload, err := encryptFreeText(master, EncryptOptions{Sections: "encrypted"}, noteContext{Record: "Rad"}, "FINDINGS: nodule. IMPRESSION: possible pneumonia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload["sections"] != nil || payload["section_enc"] == nil {
+		t.Fatalf("Sections not encrypted: %v", payload["sections"])
+	}
+
+	// only the sections of the section keys are labeled
+	labels := noteSections(payload, 5, []pks.PrivateKey{extractSectionKey(master, "IMPRESSION")})
+	if strings.Join(labels, ",") != ",,impression,impression,impression" {
+		t.Fatalf("Unexpected sections: %v", labels)
+	}
+
+	keys := []pks.PrivateKey{master.KeywordKey.Extract("pneumonia"), master.KeywordKey.Extract("nodule")}
+	opts := DecryptOptions{SectionKeys: []pks.PrivateKey{extractSectionKey(master, "impression")}, Sections: []string{"impression"}}
+	if _, hits := decryptFreeText(payload, keys, nil, opts); len(hits) != 1 || hits[0] != "pneumonia" {
+		t.Fatalf("Unexpected hits in the impression: %v", hits)
+	}
+
+	if _, hits := decryptFreeText(payload, keys, nil, DecryptOptions{}); len(hits) != 2 {
+		t.Fatalf("Unexpected hits in the note: %v", hits)
+	}
+}
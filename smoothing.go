@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/agrinman/alvis/base36"
+	"github.com/agrinman/alvis/cryptutil"
+	"github.com/agrinman/alvis/pfs"
+)
+
+// smoothingSchedule is the number of frequency tags each word is split
+// across. Words not in the schedule keep a single tag.
+type smoothingSchedule map[string]int
+
+// buildSmoothingSchedule splits every word so each of its tags is expected
+// to occur at most target times in the corpus.
+func buildSmoothingSchedule(counts map[string]int, target int) smoothingSchedule {
+	schedule := make(smoothingSchedule)
+	for w, c := range counts {
+		if splits := (c + target - 1) / target; splits > 1 {
+			schedule[w] = splits
+		}
+	}
+
+	return schedule
+}
+
+// salt picks one of a word's tags at random
+func (s smoothingSchedule) salt(word string) (salt uint32, err error) {
+	splits := s[word]
+	if splits < 2 {
+		return
+	}
+
+	b := make([]byte, 4)
+	_, err = rand.Read(b)
+	salt = binary.BigEndian.Uint32(b) % uint32(splits)
+	return
+}
+
+// mergeMap maps every salted tag to the unsalted tag of its word, both as
+// they are written by decrypt, so tags can be merged without revealing words.
+func (s smoothingSchedule) mergeMap(master MasterKey) (merge map[string]string, err error) {
+	merge = make(map[string]string)
+	for w, splits := range s {
+		canonical, encErr := base36.Encode(pfs.Tag(master.FrequencyKey, []byte(w), 0))
+		if encErr != nil {
+			err = encErr
+			return
+		}
+
+		for salt := 1; salt < splits; salt++ {
+			tag, encErr := base36.Encode(pfs.Tag(master.FrequencyKey, []byte(w), uint32(salt)))
+			if encErr != nil {
+				err = encErr
+				return
+			}
+			merge[tag] = canonical
+		}
+	}
+
+	return
+}
+
+//MARK: schedule io
+
+// The schedule names words, so it is only ever stored encrypted under a
+// sub-key of the master key.
+func writeSmoothingSchedule(master MasterKey, schedule smoothingSchedule, filepath string) (err error) {
+	scheduleBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return
+	}
+
+	ctxt, err := cryptutil.AESEncrypt(master.subKey("alvis-smoothing"), scheduleBytes)
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(filepath, ctxt, 0660)
+	return
+}
+
+func readSmoothingSchedule(master MasterKey, filepath string) (schedule smoothingSchedule, err error) {
+	ctxt, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+
+	scheduleBytes, err := cryptutil.AESDecrypt(master.subKey("alvis-smoothing"), ctxt)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(scheduleBytes, &schedule)
+	return
+}
+
+func readMergeMap(filepath string) (merge map[string]string, err error) {
+	mergeBytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(mergeBytes, &merge)
+	return
+}
+
+//MARK: Corpus counts
+
+// countCorpusTokens counts every token of the free text in the given files,
+// after PHI scrubbing and date shifting, as encrypt will see them.
+func countCorpusTokens(master MasterKey, filepaths []string, format string, opts EncryptOptions) (counts map[string]int, err error) {
+	counts = make(map[string]int)
+	for _, fp := range filepaths {
+		notes, readErr := readPlainNotes(fp, format, opts.Schema)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+
+		for _, note := range notes {
+			text, _ := opts.PHI.Scrub(note.Text)
+			text = newDateShifter(master, opts, note.Patient).Text(text)
+			for _, t := range opts.tokenizer().Split(text) {
+				counts[t] += 1
+			}
+		}
+	}
+
+	return
+}
+
+// plainNote is a plaintext note and its patient, as encrypt identifies them
+type plainNote struct {
+	Patient string
+	Text    string
+}
+
+// readFreeTexts returns the plaintext notes of a data file in any format
+func readFreeTexts(inpath string, format string, schema Schema) (texts []string, err error) {
+	notes, err := readPlainNotes(inpath, format, schema)
+	for _, note := range notes {
+		texts = append(texts, note.Text)
+	}
+	return
+}
+
+// readPlainNotes returns the plaintext notes of a data file in any format,
+// with their patients
+func readPlainNotes(inpath string, format string, schema Schema) (notes []plainNote, err error) {
+	switch format {
+	case "csv":
+		header, rows, readErr := readTableFile(inpath, schema)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		textCols, colErr := columnIndexes(header, schema.TextColumns)
+		if colErr != nil {
+			return nil, colErr
+		}
+
+		patientCol, colErr := patientColumnIndex(header, schema)
+		if colErr != nil {
+			return nil, colErr
+		}
+
+		for _, row := range rows {
+			patient := defaultPatientID(inpath)
+			if patientCol >= 0 {
+				patient = row[patientCol]
+			}
+
+			for _, i := range textCols {
+				notes = append(notes, plainNote{patient, row[i]})
+			}
+		}
+
+	case "fhir":
+		bundle, readErr := readPatientFile(inpath)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		patient := documentPatientID(bundle, schema.PatientField, inpath)
+		err = walkFHIRResources(bundle, func(resource map[string]interface{}) error {
+			for _, attachment := range fhirAttachments(resource) {
+				text, ok, decodeErr := attachmentText(attachment)
+				if decodeErr != nil {
+					return decodeErr
+				}
+				if ok {
+					notes = append(notes, plainNote{patient, text})
+				}
+			}
+
+			if text, ok := fhirValueString(resource); ok {
+				notes = append(notes, plainNote{patient, text})
+			}
+			return nil
+		})
+
+	case "hl7":
+		segments, _, readErr := readHL7File(inpath)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		for _, message := range hl7Messages(segments) {
+			patient := hl7MessagePatient(message, schema.hl7PatientField(), inpath)
+			err = ApplyCryptorToHL7(message, func(text string) (string, error) {
+				notes = append(notes, plainNote{patient, text})
+				return text, nil
+			})
+			if err != nil {
+				return
+			}
+		}
+
+	default:
+		doc, readErr := readPatientFile(inpath)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		patient := documentPatientID(doc, schema.PatientField, inpath)
+		for _, record := range recordTypes {
+			records, _ := doc[record].([]interface{})
+			for _, r := range records {
+				note, _ := r.(map[string]interface{})
+				if text, ok := note["free_text"].(string); ok {
+					notes = append(notes, plainNote{patient, text})
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// mergeTag collapses a salted tag into its word's tag, if known
+func mergeTag(merge map[string]string, tag string) string {
+	if canonical, ok := merge[tag]; ok {
+		return canonical
+	}
+
+	return tag
+}
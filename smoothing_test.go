@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCountCorpusTokensShiftsDates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-smoothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	inpath := path.Join(dir, "patient_1.json")
+	ioutil.WriteFile(inpath, []byte(`{"Car": [{"free_text": "stemi on 03/04/2012"}]}`), 0660)
+
+	opts := EncryptOptions{Schema: defaultSchema, DateMode: "shift"}
+	counts, err := countCorpusTokens(master, []string{inpath}, "json", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shifted := newDateShifter(master, opts, "patient_1").Text("stemi on 03/04/2012")
+	expected := make(map[string]int)
+	for _, token := range SplitFreeText(shifted) {
+		expected[token] += 1
+	}
+
+	if len(counts) != len(expected) {
+		t.Fatalf("Counted %v, expected %v", counts, expected)
+	}
+	for token, n := range expected {
+		if counts[token] != n {
+			t.Fatalf("Counted %v, expected %v", counts, expected)
+		}
+	}
+}
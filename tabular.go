@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/agrinman/alvis/pks"
+
+	"github.com/fatih/color"
+)
+
+//MARK: Tabular Encryption/Decryption
+func EncryptAndSaveTableFile(inpath string, outpath string, master MasterKey, opts EncryptOptions) (err error) {
+	schema := opts.Schema
+	header, rows, err := readTableFile(inpath, schema)
+	if err != nil {
+		return
+	}
+
+	textCols, err := columnIndexes(header, schema.TextColumns)
+	if err != nil {
+		return
+	}
+
+	detCols, err := columnIndexes(header, schema.DetColumns)
+	if err != nil {
+		return
+	}
+
+	dateCols, err := columnIndexes(header, schema.DateColumns)
+	if err != nil {
+		return
+	}
+
+	patientCol, err := patientColumnIndex(header, schema)
+	if err != nil {
+		return
+	}
+
+	noteDateCol, err := noteDateColumnIndex(header, schema)
+	if err != nil {
+		return
+	}
+
+	fieldKey := master.FieldKey()
+	oreDate := oreDates(master)
+
+	err = ApplyCryptorToTable(rows, func(row []string) error {
+		note := noteContext{File: inpath, Patient: defaultPatientID(inpath)}
+		if patientCol >= 0 {
+			note.Patient = row[patientCol]
+		}
+
+		dates := newDateShifter(master, opts, note.Patient)
+		for _, i := range dateCols {
+			row[i], _ = dates.Field(row[i])
+		}
+
+		if opts.DateORE && noteDateCol >= 0 {
+			var oreErr error
+			row[noteDateCol], oreErr = oreDate(row[noteDateCol])
+			if oreErr != nil {
+				return oreErr
+			}
+		}
+
+		for _, i := range textCols {
+			note.Record = header[i]
+			payload, encErr := encryptFreeText(master, opts, note, row[i])
+			if encErr != nil {
+				return encErr
+			}
+			row[i] = encodeCompactPayload(payload)
+		}
+
+		for _, i := range detCols {
+			var encErr error
+			row[i], encErr = encryptField(fieldKey, row[i])
+			if encErr != nil {
+				return encErr
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeTableFile(outpath, header, rows, schema)
+	return
+}
+
+func DecryptAndSaveTableFile(inpath string, outpath string, keywordKeys []pks.PrivateKey, freqOuter []byte, schema Schema, opts DecryptOptions) (err error) {
+	header, rows, err := readTableFile(inpath, schema)
+	if err != nil {
+		return
+	}
+
+	textCols, err := columnIndexes(header, schema.TextColumns)
+	if err != nil {
+		return
+	}
+
+	stats := make(map[string]int)
+	statsMutex := &sync.Mutex{}
+	err = ApplyCryptorToTable(rows, func(row []string) error {
+		for _, i := range textCols {
+			payload, decodeErr := decodeCompactPayload(row[i])
+			if decodeErr != nil {
+				return decodeErr
+			}
+
+			text, hits := decryptFreeText(payload, keywordKeys, freqOuter, opts)
+			row[i] = text
+
+			statsMutex.Lock()
+			for _, w := range hits {
+				stats[w] += 1
+			}
+			statsMutex.Unlock()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	color.Green("-- stats on %s --", inpath)
+	printStats(stats)
+
+	err = writeTableFile(outpath, header, rows, schema)
+	return
+}
+
+// parse helper
+func ApplyCryptorToTable(rows [][]string, cryptor func([]string) error) (err error) {
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+
+	wg.Add(len(rows))
+	for i := range rows {
+		go func(w *sync.WaitGroup, row []string) {
+			rowErr := cryptor(row)
+			if rowErr != nil {
+				errMutex.Lock()
+				if err == nil {
+					err = rowErr
+				}
+				errMutex.Unlock()
+			}
+			w.Done()
+		}(&wg, rows[i])
+	}
+	wg.Wait()
+
+	return
+}
+
+func columnIndexes(header []string, columns []string) (indexes []int, err error) {
+	for _, col := range columns {
+		found := false
+		for i, h := range header {
+			if h == col {
+				indexes = append(indexes, i)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			err = errors.New(fmt.Sprintf("Missing column: %s", col))
+			return
+		}
+	}
+
+	return
+}
+
+// patientColumnIndex is the index of the schema's patient column, or -1
+func patientColumnIndex(header []string, schema Schema) (index int, err error) {
+	return optionalColumnIndex(header, schema.PatientColumn)
+}
+
+func noteDateColumnIndex(header []string, schema Schema) (index int, err error) {
+	return optionalColumnIndex(header, schema.NoteDateColumn)
+}
+
+// optionalColumnIndex is the index of a column, or -1 if none is named
+func optionalColumnIndex(header []string, column string) (index int, err error) {
+	index = -1
+	if column == "" {
+		return
+	}
+
+	indexes, err := columnIndexes(header, []string{column})
+	if err != nil {
+		return
+	}
+
+	index = indexes[0]
+	return
+}
+
+//MARK: Compact payloads
+
+// encodeCompactPayload packs an encrypted payload into a single cell as
+// name=tok tok tok;name=tok tok tok
+func encodeCompactPayload(payload map[string]interface{}) string {
+	names := make([]string, 0, len(payload))
+	for name := range payload {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strings.Join(payloadTokens(payload, name), " ")
+	}
+
+	return strings.Join(parts, ";")
+}
+
+func decodeCompactPayload(cell string) (payload map[string]interface{}, err error) {
+	payload = make(map[string]interface{})
+
+	for _, part := range strings.Split(cell, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			err = errors.New(fmt.Sprintf("Invalid compact payload: %s", part))
+			return
+		}
+
+		payload[kv[0]] = strings.Fields(kv[1])
+	}
+
+	return
+}
+
+//MARK: table io
+func readTableFile(filepath string, schema Schema) (header []string, rows [][]string, err error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = delimiterRune(schema.Delimiter)
+	reader.LazyQuotes = schema.LazyQuotes
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return
+	}
+
+	if len(records) < 1 {
+		err = errors.New(fmt.Sprintf("Missing header row: %s", filepath))
+		return
+	}
+
+	header = records[0]
+	rows = records[1:]
+	return
+}
+
+func writeTableFile(filepath string, header []string, rows [][]string, schema Schema) (err error) {
+	file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Comma = delimiterRune(schema.Delimiter)
+
+	writer.Write(header)
+	writer.WriteAll(rows)
+
+	err = writer.Error()
+	return
+}
+
+func delimiterRune(delimiter string) rune {
+	for _, r := range delimiter {
+		return r
+	}
+	return ','
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/agrinman/alvis/pks"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alvis-csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := testMasterKey(t)
+	schema := defaultSchema
+	schema.DetColumns = []string{"mrn"}
+
+	inpath := path.Join(dir, "notes.csv")
+	ioutil.WriteFile(inpath, []byte("id,mrn,note_text\n1,M1,\"Patient has STEMI, no pneumonia\"\n2,M2,small nodule\n"), 0660)
+
+	err = EncryptAndSaveTableFile(inpath, inpath+".enc", master, EncryptOptions{Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, rows, err := readTableFile(inpath+".enc", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[0][0] != "1" || rows[0][1] == "M1" || strings.Contains(rows[0][2], "stemi") {
+		t.Fatalf("Unexpected encrypted rows: %v", rows)
+	}
+
+	err = DecryptAndSaveTableFile(inpath+".enc", inpath+".dec", []pks.PrivateKey{master.KeywordKey.Extract("stemi")}, master.FrequencyKey.OuterKey, schema, DecryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UncoverAndSaveFieldsFile(inpath+".dec", inpath+".unc", master, "csv", schema, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, rows, err = readTableFile(inpath+".unc", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keyword is decrypted, the other words are left as frequency tags
+	expected := strings.Join([]string{tagOf(master, "patient"), tagOf(master, "has"), "stemi", tagOf(master, "no"), tagOf(master, "pneumonia")}, " ")
+	if len(rows) != 2 || rows[0][1] != "M1" || rows[1][1] != "M2" || rows[0][2] != expected {
+		t.Fatalf("Unexpected decrypted rows: %v", rows)
+	}
+}
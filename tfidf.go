@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+)
+
+// tfidfDoc is one note or patient: the counts of its frequency tags and
+// keyword hits.
+type tfidfDoc struct {
+	ID     string
+	Record string
+	Counts map[string]int
+}
+
+// tfidfEntry is a non-zero weight of a document vector
+type tfidfEntry struct {
+	Feature int
+	Weight  float64
+}
+
+// tfidfMatrix is the L2 normalized TF-IDF vectors of a corpus
+type tfidfMatrix struct {
+	Docs  []tfidfDoc
+	Vocab []string
+	Rows  [][]tfidfEntry
+}
+
+//MARK: Documents
+
+// tfidfDocs turns a decrypted file into documents: one per note, or one per
+// patient ID of the file. Keyword hits count as features of their own,
+// besides their tag.
+func tfidfDocs(file corpusFile, unit string) (docs []tfidfDoc, err error) {
+	name := path.Base(file.Path)
+
+	switch unit {
+	case "patient":
+		for _, patient := range file.patients() {
+			doc := tfidfDoc{ID: patient.patient(), Counts: make(map[string]int)}
+			for _, note := range patient.Notes {
+				addNoteFeatures(doc.Counts, note)
+			}
+			docs = append(docs, doc)
+		}
+
+	case "note":
+		for i, note := range file.Notes {
+			doc := tfidfDoc{ID: fmt.Sprintf("%s#%d", name, i), Record: file.Records[i], Counts: make(map[string]int)}
+			addNoteFeatures(doc.Counts, note)
+			docs = append(docs, doc)
+		}
+
+	default:
+		err = errors.New(fmt.Sprintf("Unknown unit: %s. Expected one of: note, patient", unit))
+	}
+
+	return
+}
+
+// mergeTFIDFDocs adds up the documents of the same ID, such as a patient
+// whose notes span several files, in order of first appearance.
+func mergeTFIDFDocs(docs []tfidfDoc) (merged []tfidfDoc) {
+	index := make(map[string]int)
+	for _, doc := range docs {
+		i, ok := index[doc.ID]
+		if !ok {
+			index[doc.ID] = len(merged)
+			merged = append(merged, tfidfDoc{ID: doc.ID, Record: doc.Record, Counts: make(map[string]int)})
+			i = len(merged) - 1
+		}
+
+		for f, count := range doc.Counts {
+			merged[i].Counts[f] += count
+		}
+	}
+
+	return
+}
+
+func addNoteFeatures(counts map[string]int, note decryptedNote) {
+	for i, t := range note.Tags {
+		counts[t] += 1
+		if note.Keywords[i] != "" {
+			counts[note.Keywords[i]] += 1
+		}
+	}
+}
+
+//MARK: Weights
+
+// buildTFIDF weighs raw counts by the smoothed idf log((1+N)/(1+df)) + 1 and
+// normalizes every vector. Features in fewer than minDF documents are dropped.
+func buildTFIDF(docs []tfidfDoc, minDF int) (matrix tfidfMatrix) {
+	matrix.Docs = docs
+
+	df := make(map[string]int)
+	for _, doc := range docs {
+		for f := range doc.Counts {
+			df[f] += 1
+		}
+	}
+
+	for f, n := range df {
+		if n >= minDF {
+			matrix.Vocab = append(matrix.Vocab, f)
+		}
+	}
+	sort.Strings(matrix.Vocab)
+
+	index := make(map[string]int)
+	idf := make([]float64, len(matrix.Vocab))
+	for i, f := range matrix.Vocab {
+		index[f] = i
+		idf[i] = math.Log(float64(1+len(docs))/float64(1+df[f])) + 1
+	}
+
+	for _, doc := range docs {
+		var row []tfidfEntry
+		norm := 0.0
+		for f, count := range doc.Counts {
+			i, ok := index[f]
+			if !ok {
+				continue
+			}
+
+			weight := float64(count) * idf[i]
+			row = append(row, tfidfEntry{i, weight})
+			norm += weight * weight
+		}
+
+		norm = math.Sqrt(norm)
+		for j := range row {
+			row[j].Weight /= norm
+		}
+
+		sort.Slice(row, func(a, b int) bool { return row[a].Feature < row[b].Feature })
+		matrix.Rows = append(matrix.Rows, row)
+	}
+
+	return
+}
+
+//MARK: Output
+
+// writeLibSVM writes a line per document, labeled 0, with 1-based features
+func (m tfidfMatrix) writeLibSVM(w io.Writer) (err error) {
+	for _, row := range m.Rows {
+		line := "0"
+		for _, e := range row {
+			line += fmt.Sprintf(" %d:%.6g", e.Feature+1, e.Weight)
+		}
+
+		_, err = fmt.Fprintln(w, line)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// writeMatrixMarket writes the documents x features coordinate matrix
+func (m tfidfMatrix) writeMatrixMarket(w io.Writer) (err error) {
+	entries := 0
+	for _, row := range m.Rows {
+		entries += len(row)
+	}
+
+	fmt.Fprintln(w, "%%MatrixMarket matrix coordinate real general")
+	_, err = fmt.Fprintf(w, "%d %d %d\n", len(m.Rows), len(m.Vocab), entries)
+	if err != nil {
+		return
+	}
+
+	for i, row := range m.Rows {
+		for _, e := range row {
+			_, err = fmt.Fprintf(w, "%d %d %.6g\n", i+1, e.Feature+1, e.Weight)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (m tfidfMatrix) writeJSON(w io.Writer) (err error) {
+	type jsonDoc struct {
+		ID      string             `json:"id"`
+		Record  string             `json:"record,omitempty"`
+		Weights map[string]float64 `json:"weights"`
+	}
+
+	docs := []jsonDoc{}
+	for i, doc := range m.Docs {
+		jd := jsonDoc{ID: doc.ID, Record: doc.Record, Weights: make(map[string]float64)}
+		for _, e := range m.Rows[i] {
+			jd.Weights[m.Vocab[e.Feature]] = e.Weight
+		}
+		docs = append(docs, jd)
+	}
+
+	data, err := json.MarshalIndent(docs, "", "  ")
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return
+}
+
+// writeIndex writes the names of the rows (documents) or the columns
+// (features) of the sparse formats, one per line.
+func writeIndex(w io.Writer, names []string) (err error) {
+	for _, name := range names {
+		_, err = fmt.Fprintln(w, name)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
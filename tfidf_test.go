@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestBuildTFIDF(t *testing.T) {
+	docs := []tfidfDoc{
+		{ID: "a", Counts: map[string]int{"x": 2, "y": 1}},
+		{ID: "b", Counts: map[string]int{"x": 1}},
+	}
+
+	matrix := buildTFIDF(docs, 1)
+	if len(matrix.Vocab) != 2 || matrix.Vocab[0] != "x" || matrix.Vocab[1] != "y" {
+		t.Fatalf("Unexpected vocabulary: %v", matrix.Vocab)
+	}
+
+	// x is in every document: idf 1. y in one of two: idf log(3/2) + 1
+	x, y := 2.0, math.Log(1.5)+1
+	norm := math.Sqrt(x*x + y*y)
+	row := matrix.Rows[0]
+	if len(row) != 2 || math.Abs(row[0].Weight-x/norm) > 1e-9 || math.Abs(row[1].Weight-y/norm) > 1e-9 {
+		t.Fatalf("Unexpected weights: %v", row)
+	}
+	if row := matrix.Rows[1]; len(row) != 1 || row[0].Feature != 0 || row[0].Weight != 1 {
+		t.Fatalf("Unexpected weights: %v", row)
+	}
+
+	// y is in too few documents
+	matrix = buildTFIDF(docs, 2)
+	if len(matrix.Vocab) != 1 || len(matrix.Rows[0]) != 1 || matrix.Rows[0][0].Weight != 1 {
+		t.Fatalf("Unexpected matrix with min-df 2: %v", matrix)
+	}
+}
+
+var testTFIDFMatrix = tfidfMatrix{
+	Docs:  []tfidfDoc{{ID: "a", Record: "Car"}, {ID: "b"}},
+	Vocab: []string{"x", "y"},
+	Rows:  [][]tfidfEntry{{{0, 0.6}, {1, 0.8}}, {{1, 1}}},
+}
+
+func TestWriteLibSVM(t *testing.T) {
+	var out bytes.Buffer
+	if err := testTFIDFMatrix.writeLibSVM(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "0 1:0.6 2:0.8\n0 2:1\n"; out.String() != expected {
+		t.Fatalf("Wrote %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestWriteMatrixMarket(t *testing.T) {
+	var out bytes.Buffer
+	if err := testTFIDFMatrix.writeMatrixMarket(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "%%MatrixMarket matrix coordinate real general\n2 2 3\n1 1 0.6\n1 2 0.8\n2 2 1\n"
+	if out.String() != expected {
+		t.Fatalf("Wrote %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestWriteTFIDFJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := testTFIDFMatrix.writeJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var docs []struct {
+		ID      string
+		Record  string
+		Weights map[string]float64
+	}
+	if err := json.Unmarshal(out.Bytes(), &docs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(docs) != 2 || docs[0].ID != "a" || docs[0].Record != "Car" || docs[0].Weights["y"] != 0.8 || docs[1].Weights["y"] != 1 || len(docs[1].Weights) != 1 {
+		t.Fatalf("Unexpected documents: %v", docs)
+	}
+}
+
+func TestTFIDFDocsUnits(t *testing.T) {
+	file := corpusFile{
+		Path:     "/data/notes.csv.enc",
+		Records:  []string{"Car", "Rad", "Car"},
+		Dates:    make([]noteDate, 3),
+		Patients: []string{"p1", "p2", "p1"},
+		Notes: []decryptedNote{
+			{Tags: []string{"x", "y"}, Keywords: []string{"", "stemi"}},
+			{Tags: []string{"x"}, Keywords: []string{""}},
+			{Tags: []string{"x"}, Keywords: []string{""}},
+		},
+	}
+
+	docs, err := tfidfDocs(file, "note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 || docs[1].ID != "notes.csv.enc#1" || docs[1].Record != "Rad" || docs[0].Counts["stemi"] != 1 {
+		t.Fatalf("Unexpected note documents: %v", docs)
+	}
+
+	// one document per patient of the file
+	docs, err = tfidfDocs(file, "patient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].ID != "p1" || docs[0].Counts["x"] != 2 || docs[1].ID != "p2" || docs[1].Counts["x"] != 1 {
+		t.Fatalf("Unexpected patient documents: %v", docs)
+	}
+
+	// and one per patient across files
+	merged := mergeTFIDFDocs(append(docs, tfidfDoc{ID: "p1", Counts: map[string]int{"z": 1}}))
+	if len(merged) != 2 || merged[0].Counts["x"] != 2 || merged[0].Counts["z"] != 1 {
+		t.Fatalf("Unexpected merged documents: %v", merged)
+	}
+
+	if _, err = tfidfDocs(file, "file"); err == nil {
+		t.Fatal("Accepted an unknown unit")
+	}
+}
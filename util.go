@@ -1,9 +1,11 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path"
 )
@@ -33,3 +35,13 @@ func getFilePathsIn(dirpath string) (filepaths []string, err error) {
 
 	return
 }
+
+// randIntn is a uniform random int in [0, n) from crypto/rand
+func randIntn(n int) (int, error) {
+	r, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(r.Int64()), nil
+}